@@ -0,0 +1,33 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"fmt"
+	"io"
+)
+
+// CollisionTest reads tries blocks of blockSize bytes from r and returns an
+// error if the same block is returned twice or if r fails.
+//
+// It is a smoke test, not a statistical test suite. Define X = 2^(8*blockSize).
+// The probability of a collision for a random source is
+// 1 - X! / (X^tries * (X-tries)!) so blockSize must be large enough to make
+// false positives negligible, e.g. 16 bytes.
+func CollisionTest(r io.Reader, tries, blockSize int) error {
+	blocks := make(map[string]bool, tries)
+	data := make([]byte, blockSize)
+	for i := 0; i < tries; i++ {
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		str := string(data)
+		if blocks[str] {
+			return fmt.Errorf("the same %d bytes block was returned 2 times after %d requests (out of %d): %x", blockSize, i, tries, data)
+		}
+		blocks[str] = true
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCollisionTest(t *testing.T) {
+	t.Parallel()
+	if err := CollisionTest(rand.Reader, 1000, 16); err != nil {
+		t.Fatal(err)
+	}
+	if err := CollisionTest(newFortuna(t), 1000, 16); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollisionTestFail(t *testing.T) {
+	t.Parallel()
+	if err := CollisionTest(bytes.NewReader(make([]byte, 32)), 2, 16); err == nil {
+		t.Fatal("No error set")
+	}
+	// The reader is exhausted before the collision.
+	if err := CollisionTest(bytes.NewReader(make([]byte, 16)), 2, 16); err == nil {
+		t.Fatal("No error set")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// CompressibilityRatio reads n bytes from r, compresses them with flate and
+// returns the compressed size divided by n.
+//
+// Random data can't be compressed so a working generator yields a ratio
+// slightly above 1 because of the flate framing. A ratio well below 1 denotes
+// a broken setup. Like CollisionTest, it is a smoke test, not a statistical
+// test suite.
+func CompressibilityRatio(r io.Reader, n int) (float64, error) {
+	if n <= 0 {
+		return 0, errors.New("invalid argument to CompressibilityRatio")
+	}
+	w := &countingWriter{}
+	f, err := flate.NewWriter(w, flate.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(f, r, int64(n)); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return float64(w.n) / float64(n), nil
+}
+
+// countingWriter discards the data written to it and counts the bytes.
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
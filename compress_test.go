@@ -0,0 +1,39 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressibilityRatio(t *testing.T) {
+	t.Parallel()
+	r, err := CompressibilityRatio(newFortuna(t), 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r < 1. || r > 1.01 {
+		t.Fatalf("Got %f", r)
+	}
+	r, err = CompressibilityRatio(bytes.NewReader(make([]byte, 1<<16)), 1<<16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r > 0.01 {
+		t.Fatalf("Got %f", r)
+	}
+}
+
+func TestCompressibilityRatioFail(t *testing.T) {
+	t.Parallel()
+	if _, err := CompressibilityRatio(newFortuna(t), 0); err == nil {
+		t.Fatal("No error set")
+	}
+	// The reader is exhausted.
+	if _, err := CompressibilityRatio(bytes.NewReader(make([]byte, 16)), 32); err == nil {
+		t.Fatal("No error set")
+	}
+}
@@ -4,6 +4,8 @@
 
 package fortuna
 
+import "sync"
+
 // Little Endian counter.
 type counter []byte
 
@@ -20,3 +22,100 @@ func (c counter) incr() {
 		c[i] = 0
 	}
 }
+
+// add adds n to c by treating it as a little endian big int. It wraps on
+// overflow.
+func (c counter) add(n uint64) {
+	carry := uint64(0)
+	for i := 0; i < len(c) && (n != 0 || carry != 0); i++ {
+		s := uint64(c[i]) + n&0xFF + carry
+		c[i] = byte(s)
+		carry = s >> 8
+		n >>= 8
+	}
+}
+
+// addBE adds n to c by treating it as a big endian big int, like
+// cipher.NewCTR. It wraps on overflow.
+func (c counter) addBE(n uint64) {
+	carry := uint64(0)
+	for i := len(c) - 1; i >= 0 && (n != 0 || carry != 0); i-- {
+		s := uint64(c[i]) + n&0xFF + carry
+		c[i] = byte(s)
+		carry = s >> 8
+		n >>= 8
+	}
+}
+
+// untilWrap returns the number of calls to incr() before c wraps to zero,
+// saturated to the maximum uint64 value.
+func (c counter) untilWrap() uint64 {
+	for i := 8; i < len(c); i++ {
+		if c[i] != 255 {
+			return ^uint64(0)
+		}
+	}
+	// The inverse of the low bits is the remaining count minus one.
+	var left uint64
+	for i := len(c) - 1; i >= 0; i-- {
+		if i < 8 {
+			left = left<<8 | uint64(^c[i])
+		}
+	}
+	if left == ^uint64(0) {
+		return left
+	}
+	return left + 1
+}
+
+// untilWrapBE is the same as untilWrap for a big endian counter.
+func (c counter) untilWrapBE() uint64 {
+	var b [16]byte
+	r := counter(b[:len(c)])
+	for i := range c {
+		r[len(c)-1-i] = c[i]
+	}
+	return r.untilWrap()
+}
+
+// Counter is a little endian big int of a fixed size, like the one used by the
+// generator as the AES-CTR IV. It wraps to zero on overflow.
+//
+// The resulting object is thread-safe.
+type Counter struct {
+	lock sync.Mutex
+	c    counter
+}
+
+// NewCounter returns a Counter of size bytes set to zero.
+func NewCounter(size int) *Counter {
+	return &Counter{c: make(counter, size)}
+}
+
+// Incr adds 1 to the counter.
+func (c *Counter) Incr() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.c.incr()
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.c.add(n)
+}
+
+// Bytes returns a copy of the counter's value.
+func (c *Counter) Bytes() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]byte{}, c.c...)
+}
+
+// Set sets the counter's value and size to a copy of b.
+func (c *Counter) Set(b []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.c = append(counter{}, b...)
+}
@@ -6,6 +6,7 @@ package fortuna
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 )
 
@@ -33,3 +34,137 @@ func TestCounter(t *testing.T) {
 		}
 	}
 }
+
+func TestCounterUntilWrap(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		c        counter
+		expected uint64
+	}{
+		{counter{255}, 1},
+		{counter{0}, 256},
+		{counter{254, 255}, 2},
+		{counter{0, 0}, 65536},
+		{counter{255, 254}, 257},
+		{make(counter, 16), ^uint64(0)},
+		{counter{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255}, 6},
+		{counter{250, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 255, 254}, ^uint64(0)},
+	}
+	for i, line := range data {
+		if actual := line.c.untilWrap(); actual != line.expected {
+			t.Fatalf("%d: %d != %d", i, actual, line.expected)
+		}
+		// Confirm by incrementing.
+		if line.expected < 1000 {
+			c := append(counter{}, line.c...)
+			for j := uint64(1); j < line.expected; j++ {
+				c.incr()
+			}
+			if bytes.Equal(c, make(counter, len(c))) {
+				t.Fatalf("%d: wrapped early", i)
+			}
+			if c.incr(); !bytes.Equal(c, make(counter, len(c))) {
+				t.Fatalf("%d: didn't wrap", i)
+			}
+		}
+	}
+}
+
+func TestCounterAdd(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		c        counter
+		n        uint64
+		expected counter
+	}{
+		{counter{0}, 0, counter{0}},
+		{counter{0}, 255, counter{255}},
+		{counter{1}, 255, counter{0}},
+		{counter{255, 0}, 1, counter{0, 1}},
+		{counter{0, 0, 0}, 0x10203, counter{3, 2, 1}},
+		{counter{255, 255, 0}, 0x101, counter{0, 1, 1}},
+		{counter{255, 255}, 2, counter{1, 0}},
+		{make(counter, 16), ^uint64(0), counter{255, 255, 255, 255, 255, 255, 255, 255, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{counter{1, 0, 0, 0, 0, 0, 0, 0, 0}, ^uint64(0), counter{0, 0, 0, 0, 0, 0, 0, 0, 1}},
+	}
+	for i, line := range data {
+		c := append(counter{}, line.c...)
+		if c.add(line.n); !bytes.Equal(c, line.expected) {
+			t.Fatalf("%d: %v + %d == %v != %v", i, line.c, line.n, c, line.expected)
+		}
+	}
+}
+
+func TestCounterBigEndian(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		c        counter
+		n        uint64
+		expected counter
+	}{
+		{counter{0}, 255, counter{255}},
+		{counter{0, 255}, 1, counter{1, 0}},
+		{counter{0, 0, 0}, 0x10203, counter{1, 2, 3}},
+		{counter{255, 255}, 2, counter{0, 1}},
+	}
+	for i, line := range data {
+		c := append(counter{}, line.c...)
+		if c.addBE(line.n); !bytes.Equal(c, line.expected) {
+			t.Fatalf("%d: %v + %d == %v != %v", i, line.c, line.n, c, line.expected)
+		}
+	}
+	c := make(counter, 16)
+	for i := range c {
+		c[i] = 255
+	}
+	c[15] = 253
+	if w := c.untilWrapBE(); w != 3 {
+		t.Fatalf("Got %d", w)
+	}
+	if w := make(counter, 16).untilWrapBE(); w != ^uint64(0) {
+		t.Fatalf("Got %d", w)
+	}
+}
+
+func TestExportedCounter(t *testing.T) {
+	t.Parallel()
+	for _, i := range counterTestData {
+		c := NewCounter(0)
+		c.Set(i[0])
+		c.Incr()
+		if actual := c.Bytes(); !bytes.Equal(actual, i[1]) {
+			t.Fatalf("%v + 1 == %v != %v", i[0], actual, i[1])
+		}
+	}
+	c := NewCounter(2)
+	c.Add(0x1FF)
+	if actual := c.Bytes(); !bytes.Equal(actual, []byte{255, 1}) {
+		t.Fatalf("Got %v", actual)
+	}
+	// Bytes returns a copy.
+	c.Bytes()[0] = 0
+	if actual := c.Bytes(); actual[0] != 255 {
+		t.Fatalf("Got %v", actual)
+	}
+}
+
+func TestExportedCounterConcurrent(t *testing.T) {
+	t.Parallel()
+	c := NewCounter(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Incr()
+				c.Add(2)
+			}
+		}()
+	}
+	wg.Wait()
+	// 8 * 1000 * 3 == 0x5DC0
+	if actual := c.Bytes(); !bytes.Equal(actual, []byte{0xC0, 0x5D, 0, 0}) {
+		t.Fatalf("Got %v", actual)
+	}
+}
@@ -8,6 +8,7 @@
 package fortuna
 
 import (
+	"encoding/binary"
 	"hash"
 )
 
@@ -45,3 +46,31 @@ func DoubleHash(h hash.Hash, data ...[]byte) []byte {
 	}
 	return h.Sum(nil)
 }
+
+// DoubleHashXOF returns outLen bytes derived from data. It panics if outLen is
+// negative.
+//
+// The first block is DoubleHash(h(), data...). Each following block i is
+// h(d || i), with d the first block and i a 32 bits little endian counter
+// starting at 1. Since all the blocks are derived from the first one, the
+// output must be used as a whole, e.g. as a single key. It must not be split
+// into keys that are disclosed independently.
+func DoubleHashXOF(h func() hash.Hash, outLen int, data ...[]byte) []byte {
+	if outLen < 0 {
+		panic("invalid argument to DoubleHashXOF")
+	}
+	hh := h()
+	d := DoubleHash(hh, data...)
+	out := make([]byte, 0, outLen+len(d))
+	out = append(out, d...)
+	var c [4]byte
+	for i := uint32(1); len(out) < outLen; i++ {
+		binary.LittleEndian.PutUint32(c[:], i)
+		hh.Reset()
+		_, _ = hh.Write(d)
+		_, _ = hh.Write(c[:])
+		out = hh.Sum(out)
+	}
+	Wipe(d)
+	return out[:outLen]
+}
@@ -39,3 +39,30 @@ func TestDoubleHash(t *testing.T) {
 		}
 	}
 }
+
+func TestDoubleHashXOF(t *testing.T) {
+	t.Parallel()
+	data := []byte("data")
+	out := DoubleHashXOF(sha256.New, 100, data)
+	if len(out) != 100 {
+		t.Fatalf("Got %d", len(out))
+	}
+	if expected := DoubleHash(sha256.New(), data); !bytes.Equal(expected, out[:sha256.Size]) {
+		t.Fatalf("%x != %x", out[:sha256.Size], expected)
+	}
+	blocks := map[string]bool{}
+	for i := 0; i < len(out); i += sha256.Size {
+		b := string(out[i:min(i+sha256.Size, len(out))])
+		if blocks[b] {
+			t.Fatalf("Block %d is repeated", i/sha256.Size)
+		}
+		blocks[b] = true
+	}
+	// Shorter outputs are prefixes.
+	if short := DoubleHashXOF(sha256.New, 40, data); !bytes.Equal(out[:40], short) {
+		t.Fatalf("%x != %x", short, out[:40])
+	}
+	if l := len(DoubleHashXOF(sha256.New, 0, data)); l != 0 {
+		t.Fatalf("Got %d", l)
+	}
+}
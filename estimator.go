@@ -0,0 +1,55 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"math"
+	"sync"
+)
+
+// estimator keeps a histogram of the bytes seen from each source to compute a
+// coarse min-entropy estimate.
+//
+// Fortuna's security doesn't rely on it in any way; it's only a diagnostic to
+// detect a dead source, e.g. one that sends constant data.
+type estimator struct {
+	lock       sync.Mutex
+	histograms [256]*[256]uint64 // Lazily allocated
+	totals     [256]uint64
+}
+
+func (e *estimator) add(source byte, data [][]byte) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	h := e.histograms[source]
+	if h == nil {
+		h = &[256]uint64{}
+		e.histograms[source] = h
+	}
+	for _, d := range data {
+		for _, b := range d {
+			h[b]++
+		}
+		e.totals[source] += uint64(len(d))
+	}
+}
+
+// estimate returns the min-entropy in bits per byte of the data seen from
+// source, between 0 and 8.
+func (e *estimator) estimate(source byte) float64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	h := e.histograms[source]
+	if h == nil || e.totals[source] == 0 {
+		return 0
+	}
+	max := uint64(0)
+	for _, c := range h {
+		if c > max {
+			max = c
+		}
+	}
+	return -math.Log2(float64(max) / float64(e.totals[source]))
+}
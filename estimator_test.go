@@ -0,0 +1,52 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEntropyEstimate(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFortunaWithOptions(raw, Options{EstimateEntropy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := f.EntropyEstimate(1); e != 0 {
+		t.Fatalf("Got %f", e)
+	}
+	constant := make([]byte, 32)
+	random := make([]byte, 32)
+	for i := 0; i < 1000; i++ {
+		if _, err := rand.Read(random); err != nil {
+			t.Fatal(err)
+		}
+		f.AddRandomEventSync(1, constant)
+		f.AddRandomEventSync(2, random[:16], random[16:])
+	}
+	if e := f.EntropyEstimate(1); e > 0.01 {
+		t.Fatalf("Constant source: %f", e)
+	}
+	// The theoretical value is 8 but the estimate is biased low on 32000
+	// samples.
+	if e := f.EntropyEstimate(2); e < 7 {
+		t.Fatalf("Random source: %f", e)
+	}
+}
+
+func TestEntropyEstimateDisabled(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	prng.AddRandomEventSync(1, []byte{1, 2, 3})
+	if e := prng.EntropyEstimate(1); e != 0 {
+		t.Fatalf("Got %f", e)
+	}
+}
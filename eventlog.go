@@ -0,0 +1,58 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// An event log record is the source, the length of the framed data and its
+// SHA-256 digest.
+const eventLogRecordSize = 2 + sha256.Size
+
+func (a *accumulator) StartEventLog(w io.Writer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.eventLog = w
+}
+
+// logEvent writes the record of a framed event to the event log.
+//
+// This method must be called with the lock held.
+func (a *accumulator) logEvent(buffer []byte) {
+	var rec [eventLogRecordSize]byte
+	rec[0] = buffer[0]
+	rec[1] = byte(len(buffer) - 2)
+	d := sha256.Sum256(buffer[2:])
+	copy(rec[2:], d[:])
+	if _, err := a.eventLog.Write(rec[:]); err != nil {
+		a.eventLog = nil
+	}
+}
+
+// ReplayEventLog adds the events recorded by Fortuna.StartEventLog() to f, in
+// the same order.
+//
+// The data of each event is derived from its digest with the same length as
+// the original, so f's pools receive the same amount of data in the same
+// order, but not the original data. This reproduces the reseed schedule, not
+// the output.
+func ReplayEventLog(f Fortuna, r io.Reader) error {
+	var rec [eventLogRecordSize]byte
+	for {
+		if _, err := io.ReadFull(r, rec[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return errors.New("truncated event log")
+			}
+			return err
+		}
+		f.AddRandomEventSync(rec[0], DoubleHashXOF(sha256.New, int(rec[1]), rec[2:]))
+	}
+}
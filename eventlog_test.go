@@ -0,0 +1,49 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEventLog(t *testing.T) {
+	t.Parallel()
+	a := newFortuna(t)
+	b := newFortuna(t)
+	log := bytes.Buffer{}
+	a.StartEventLog(&log)
+	for i := 0; i < 200; i++ {
+		a.AddRandomEventSync(byte(i%3), make([]byte, 1+i%40))
+	}
+	a.StartEventLog(nil)
+	a.AddRandomEventSync(1, []byte{1})
+	if log.Len() != 200*eventLogRecordSize {
+		t.Fatalf("Got %d", log.Len())
+	}
+	if err := ReplayEventLog(b, &log); err != nil {
+		t.Fatal(err)
+	}
+	b.AddRandomEventSync(1, []byte{1})
+	for i := 0; i < 10; i++ {
+		for j := range a.pools {
+			if a.pools[j].length != b.pools[j].length {
+				t.Fatalf("%d: pool %d: %d != %d", i, j, a.pools[j].length, b.pools[j].length)
+			}
+		}
+		a.ForceReseed()
+		b.ForceReseed()
+	}
+	if a.PoolDrainCounts() != b.PoolDrainCounts() {
+		t.Fatalf("%v != %v", a.PoolDrainCounts(), b.PoolDrainCounts())
+	}
+}
+
+func TestEventLogTruncated(t *testing.T) {
+	t.Parallel()
+	if err := ReplayEventLog(newFortuna(t), bytes.NewReader(make([]byte, eventLogRecordSize+1))); err == nil {
+		t.Fatal("No error set")
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"io"
+)
+
+// FillArray16 fills p completely with random data read from r, e.g. an
+// AES-128 key.
+func FillArray16(r io.Reader, p *[16]byte) error {
+	_, err := io.ReadFull(r, p[:])
+	return err
+}
+
+// FillArray24 fills p completely with random data read from r, e.g. an
+// AES-192 key.
+func FillArray24(r io.Reader, p *[24]byte) error {
+	_, err := io.ReadFull(r, p[:])
+	return err
+}
+
+// FillArray32 fills p completely with random data read from r, e.g. an
+// AES-256 key.
+func FillArray32(r io.Reader, p *[32]byte) error {
+	_, err := io.ReadFull(r, p[:])
+	return err
+}
+
+// FillArray64 fills p completely with random data read from r, e.g. an
+// HMAC-SHA-512 key.
+func FillArray64(r io.Reader, p *[64]byte) error {
+	_, err := io.ReadFull(r, p[:])
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func isZero(b []byte) bool {
+	return bytes.Equal(b, make([]byte, len(b)))
+}
+
+func TestFillArray(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	var a16 [16]byte
+	if err := FillArray16(prng, &a16); err != nil || isZero(a16[:]) {
+		t.Fatalf("FillArray16: %v %v", err, a16)
+	}
+	var a24 [24]byte
+	if err := FillArray24(prng, &a24); err != nil || isZero(a24[:]) {
+		t.Fatalf("FillArray24: %v %v", err, a24)
+	}
+	var a32 [32]byte
+	if err := FillArray32(prng, &a32); err != nil || isZero(a32[:]) {
+		t.Fatalf("FillArray32: %v %v", err, a32)
+	}
+	var a64 [64]byte
+	if err := FillArray64(prng, &a64); err != nil || isZero(a64[:]) {
+		t.Fatalf("FillArray64: %v %v", err, a64)
+	}
+}
+
+// Ensures the full array is filled even if the reader returns one byte at a
+// time.
+func TestFillArrayShortReads(t *testing.T) {
+	t.Parallel()
+	g := NewGenerator(nil, []byte{0})
+	var a [64]byte
+	if err := FillArray64(oneByteReader{g}, &a); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, 64)
+	g = NewGenerator(nil, []byte{0})
+	for i := range expected {
+		read(t, g, expected[i:i+1], 1)
+	}
+	if !bytes.Equal(a[:], expected) {
+		t.Fatalf("%v != %v", a, expected)
+	}
+}
+
+// oneByteReader reads at most one byte at a time.
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
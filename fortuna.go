@@ -12,13 +12,21 @@
 package fortuna
 
 import (
-	"crypto/sha1"
+	"context"
+	"crypto/aes"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"log/slog"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 const (
@@ -31,21 +39,342 @@ const (
 	// pools ensure that even at 10 reseeds per second, it will take more than 13
 	// years before P32 would ever be used. See section 9.5.2 p. 149-150.
 	numPools = 32
-	// Do not reseed unless the pool has generated this amount of data.
+	// Number of reseed times kept for ReseedRate.
+	reseedHistory = 64
+	// Do not reseed unless the pool has generated this amount of data. This is
+	// the value for the default SHA-256 pool hash; see Options.PoolHash.
 	minPoolSize = sha256.BlockSize
 )
 
+// MinSeedSize is the minimum seed length accepted by NewFortuna. It is larger
+// when Options.PoolHash has a larger block size.
+const MinSeedSize = 2 * minPoolSize
+
+// ErrClosed is returned when using a Fortuna instance after Close() was
+// called.
+var ErrClosed = errors.New("instance is closed")
+
+// ErrNotSeeded is returned by Read on an instance created with NewFortunaLazy
+// until its seed function returned.
+var ErrNotSeeded = errors.New("instance is not seeded yet")
+
+// ErrInsufficientEntropy is returned by Read when Options.RequireRealEntropy
+// is set and the instance was not yet reseeded with entropy added after its
+// construction.
+var ErrInsufficientEntropy = errors.New("instance was not reseeded with entropy yet")
+
+// ErrWeakSeed is returned, along a working result, when crypto/rand failed and
+// weak inputs were used instead. See NewFortunaAutoSeed, ReseedFromSystem and
+// OSRandomSource.
+var ErrWeakSeed = errors.New("crypto/rand failed, seeded from weak inputs")
+
+// systemRand is crypto/rand.Reader, except in tests.
+var systemRand io.Reader = rand.Reader
+
 // Fortuna implements a cryptographic random number generator. It is used as an
 // randomness entropy pool. Randomness can be read from and entropy can be
 // added via AddRandomEvent().
+//
+// All the methods are safe to call concurrently. The pools and the reseed
+// schedule are protected by the accumulator lock and the generator has its own
+// lock, so reads don't serialize on the accumulator except for the short
+// reseed check. Events added with AddRandomEvent() are written to the pools
+// asynchronously, in an unspecified order; use AddRandomEventSync() when the
+// event must be in a pool before the next Read().
 type Fortuna interface {
 	io.Reader
 
+	// ReadContext is the same as Read except that when
+	// Options.BlockUntilSeeded is set, the wait for IsSeeded() to become true
+	// is canceled when ctx is done.
+	ReadContext(ctx context.Context, data []byte) (int, error)
+
+	// ReadAtMost does a single generator request and returns
+	// min(len(p), Options.MaxBytesPerRequest) bytes, 1Mb by default. Read has
+	// the same behavior; ReadAtMost makes the cap explicit at the call site.
+	// Use NewFullReader() or io.ReadFull() to fill larger buffers.
+	ReadAtMost(p []byte) (int, error)
+
+	// ReadInto fills buf completely with multiple requests of at most the
+	// maximum request size, unless an error occurs. Unlike FillLarge, the
+	// reseed check is done before each request and concurrent reads are
+	// interleaved.
+	//
+	// Each request allocates the AES cipher of its key, since the key is
+	// replaced after each request.
+	ReadInto(buf []byte) error
+
+	// FillLarge fills p completely. The generator is rekeyed every maximum
+	// request size, like a loop of Read calls, but the reseed check and the
+	// locking are done only once so the output is the same as such a loop in
+	// the absence of reseed.
+	//
+	// Concurrent reads are blocked for the whole duration, which is on the
+	// order of 1ms per Mb.
+	FillLarge(p []byte) error
+
+	// ReadFresh is the same as Read except that the generator is first
+	// reseeded, independently of the reseed interval, when pool 0 has enough
+	// entropy. Otherwise it reads from the current generator state and
+	// LastReadWasFresh() returns false.
+	ReadFresh(p []byte) (int, error)
+
+	// LastReadWasFresh returns true if the last call to ReadFresh() reseeded
+	// the generator. The value is shared by all callers so it is only
+	// meaningful when ReadFresh() calls are not concurrent.
+	LastReadWasFresh() bool
+
+	// StreamToWithDigest writes n bytes of random data to w and returns the
+	// number of bytes written and the digest of h over them, e.g. to write a
+	// random file and record its checksum. h is reset first.
+	StreamToWithDigest(w io.Writer, n int64, h hash.Hash) (int64, []byte, error)
+
+	// StreamToContext writes n bytes of random data to w in chunks of 32kb. It
+	// checks ctx between chunks and returns the number of bytes written with
+	// ctx.Err() when ctx is done.
+	StreamToContext(ctx context.Context, w io.Writer, n int64) (int64, error)
+
+	// ReadVectored fills each buffer of bufs in turn as if they were a single
+	// contiguous buffer. Unlike Read, the buffers are completely filled, with
+	// the generator rekeyed every maximum request size. It returns the total
+	// number of bytes written.
+	//
+	// It is cheaper than multiple calls to Read to fill a few small buffers.
+	ReadVectored(bufs ...[]byte) (int, error)
+
+	// IsSeeded returns true once the generator was reseeded from at least one
+	// pool containing entropy added after the instance was constructed, i.e.
+	// the output doesn't only depend on the initial seed anymore.
+	IsSeeded() bool
+
+	// SeededFromEvents returns true once the generator was reseeded from at
+	// least one pool containing only entropy added after the construction,
+	// i.e. a pool that was emptied by a reseed since the initial seed was
+	// distributed and then received events. It is stricter than IsSeeded(),
+	// which also accepts a pool where the events were mixed with the initial
+	// seed.
+	SeededFromEvents() bool
+
+	// LastReseed returns the time of the last reseed of the generator.
+	LastReseed() time.Time
+
+	// NextReseed returns the earliest time at which the next reseed can occur.
+	// The reseed also requires pool 0 to have accumulated enough entropy so it
+	// may happen later.
+	NextReseed() time.Time
+
+	// ReseedNotify returns a channel that receives the number of reseeds done
+	// so far after each reseed. The value is dropped if the channel's buffer of
+	// one is full, so a reseed never blocks on a slow receiver. All the calls
+	// return the same channel.
+	ReseedNotify() <-chan int
+
+	// ReseedRate returns the number of reseeds per second over the trailing
+	// window. Only the last reseedHistory reseeds are kept so the rate is
+	// capped at reseedHistory/window. Outside of forced reseeds, it is at
+	// most 10 per second following reseedInterval.
+	ReseedRate(window time.Duration) float64
+
+	// ForceReseed reseeds the generator from the pools right away,
+	// independently of the reseed interval and of the amount of entropy
+	// accumulated in pool 0.
+	ForceReseed()
+
+	// ReseedDrainAll reseeds the generator right away from all the non-empty
+	// pools, outside the normal schedule, and empties them. It is meant for
+	// incident response, e.g. after a suspected compromise of the state, to use
+	// all the entropy accumulated so far. It counts as a reseed for Stats(),
+	// ReseedRate() and ReseedNotify().
+	//
+	// It defeats the protection the pool schedule provides against an attacker
+	// controlling some sources so it must not be called routinely.
+	ReseedDrainAll()
+
+	// PoolDrainCounts returns the number of times each pool was used to reseed
+	// the generator since the instance was constructed. Following the reseed
+	// schedule, pool i is used every 2^i reseeds.
+	PoolDrainCounts() [numPools]int
+
+	// MarshalPools serializes the state of the entropy pools and the reseed
+	// schedule, but not the generator, e.g. for a forensic snapshot of the
+	// accumulation. The pool hash must implement encoding.BinaryMarshaler,
+	// like SHA-256. The data must be kept secret since it determines the next
+	// reseeds.
+	MarshalPools() ([]byte, error)
+
+	// UnmarshalPools restores the state saved by MarshalPools(). The generator
+	// is not part of the data so the instance keeps its own generator and its
+	// output differs from the one of the instance that was saved.
+	UnmarshalPools(data []byte) error
+
+	// Validate checks the invariants of the internal state, e.g. after
+	// UnmarshalPools(), and returns an error describing the first violation.
+	Validate() error
+
+	// Stats returns a snapshot of the accumulator's counters, e.g. to serve
+	// from a monitoring endpoint. It never contains secret data.
+	Stats() Stats
+
+	// ResetPools empties the entropy pools and restarts the pool schedule as if
+	// no reseed occurred yet. The generator key is kept. It is useful after
+	// importing a state to only accumulate fresh entropy.
+	ResetPools()
+
 	// AddRandomEvent adds random data (entropy) from the given source. data
 	// should be in general 32 bytes or less. It is not useful to add more than
 	// 32 bytes of entropy at a time. If the data is more than 32 bytes, it will
-	// hashed first.
-	AddRandomEvent(source byte, data []byte)
+	// hashed first with Options.EventHash.
+	//
+	// When multiple slices are passed, they are processed as a single event as
+	// if they had been concatenated. An empty event is ignored and doesn't use
+	// a pool slot.
+	//
+	// The event is usually processed in the background but the call blocks on
+	// the accumulator when it is saturated: with Options.Workers when the queue
+	// is full, otherwise when Options.MaxEventGoroutines is reached, since the
+	// event is then processed inline.
+	AddRandomEvent(source byte, data ...[]byte)
+
+	// Flush waits for the events added with AddRandomEvent() before the call to
+	// be written to the pools.
+	Flush()
+
+	// AddTiming adds the current time, with nanosecond resolution, as an event
+	// from the given source, e.g. when a request is received.
+	AddTiming(source byte)
+
+	// AddRandomEventSync is the same as AddRandomEvent except that the data is
+	// written to the pool before the function returns.
+	AddRandomEventSync(source byte, data ...[]byte)
+
+	// AddRandomEventErr is the same as AddRandomEvent except that it returns
+	// ErrClosed if the instance is closed. AddRandomEvent silently drops the
+	// data in this case.
+	AddRandomEventErr(source byte, data ...[]byte) error
+
+	// AddSource starts a goroutine that runs s.Collect. The events it emits are
+	// added with the given source byte. Collect's context is canceled on
+	// Close, which doesn't wait for Collect to return. Use NewTimeoutSource to
+	// bound the duration of a single Collect call.
+	AddSource(source byte, s EntropySource)
+
+	// Generator returns a handle to the internal generator.
+	//
+	// Read on the handle is the same as Read on the instance: the generator is
+	// reseeded from the pools first when due. Write directly reseeds the
+	// generator with the data, bypassing the entropy pools.
+	//
+	// Sharp edges: data written is mixed in the key right away so it doesn't
+	// benefit from the pools' protection against attacker-controlled events,
+	// it doesn't count toward IsSeeded() and the handle is not a separate
+	// stream, all the data read through it is consumed from the instance's
+	// output. The handle returns ErrClosed once the instance is closed.
+	Generator() io.ReadWriter
+
+	// SetGenerator replaces the internal generator state with the one of g,
+	// which must be a seeded generator returned by NewGenerator, e.g. to
+	// switch to another hash. The hash and key size become g's while the other
+	// options of the instance, like Options.MaxBytesPerRequest, are kept.
+	// Concurrent reads either complete with the previous state or use the new
+	// one. g must not be used afterward.
+	SetGenerator(g io.ReadWriter) error
+
+	// SecurityBits returns the security level of the generator in bits, e.g.
+	// 128 with the default SHA-256. It can be used at startup to fail fast on a
+	// misconfiguration.
+	SecurityBits() int
+
+	// BytesUntilRekey returns the maximum number of bytes a Read returns
+	// before the generator rekeys. The generator rekeys at the end of each
+	// Read so it doesn't decrease as data is read; it is lower than the maximum
+	// request size only right before the counter wraps.
+	BytesUntilRekey() int
+
+	// ReseedFromScratch resets the instance to the state of a new instance
+	// constructed with seed, reusing its memory. The options, the audit hash
+	// and the entropy estimates are kept. It is meant for tests that need many
+	// fresh instances.
+	ReseedFromScratch(seed []byte) error
+
+	// StartEventLog writes a record for each event written to the pools to w,
+	// in the order they are written, for ReplayEventLog(). Use nil to stop.
+	// The log is stopped if a write to w fails.
+	//
+	// w is called with the accumulator lock held so it must be fast. The data
+	// of each event is hashed but low entropy events, like timings, can be
+	// brute forced from their hash so the log must not be disclosed.
+	StartEventLog(w io.Writer)
+
+	// Close wipes the internal state. Afterward, Read returns ErrClosed and
+	// events are dropped.
+	Close() error
+
+	// ReseedFromSystem reads 32 bytes from crypto/rand, adds them to the
+	// entropy pools and forces a reseed of the generator, independently of the
+	// reseed interval. It is useful right before generating a long lived key.
+	//
+	// If crypto/rand fails, weak inputs are used instead like in
+	// NewFortunaAutoSeed and the returned error wraps ErrWeakSeed.
+	ReseedFromSystem() error
+
+	// SeedFromDevice reads n bytes from the file at path, e.g. a hardware
+	// entropy device, adds them to the entropy pools in events of 32 bytes and
+	// forces a reseed of the generator. It returns an error if the device
+	// can't provide n bytes.
+	SeedFromDevice(path string, n int) error
+
+	// PrimePools reads data from entropy and adds it as events of 32 bytes
+	// until each pool has enough data to be used by a reseed, so IsSeeded()
+	// becomes true at the next reseed. It returns an error if entropy fails
+	// before.
+	PrimePools(entropy io.Reader) error
+
+	// ReseedExternal reads 32 bytes with ReadFresh() and writes them to g, e.g.
+	// to periodically inject entropy in a deterministic generator managed by
+	// the user, like the one returned by NewGenerator.
+	ReseedExternal(g io.Writer) error
+
+	// SetAuditHash sets a hash that receives every byte returned by Read(), so
+	// a digest of all the randomness produced can be kept without storing it.
+	// Use nil to stop auditing.
+	//
+	// Auditing adds the hashing cost to each Read() and serializes concurrent
+	// reads so the digest reflects the order in which the data was returned.
+	SetAuditHash(h hash.Hash)
+
+	// AuditDigest returns the current digest of the audit hash or nil if none
+	// is set.
+	AuditDigest() []byte
+
+	// EntropyEstimate returns a coarse min-entropy estimate, in bits per byte,
+	// of the events added for source. It returns 0 unless
+	// Options.EstimateEntropy is set.
+	//
+	// Fortuna deliberately doesn't estimate entropy and its security doesn't
+	// rely on this value. It is only a diagnostic to detect a dead source, e.g.
+	// one that sends constant data.
+	EntropyEstimate(source byte) float64
+
+	// Uint64n returns a uniformly distributed random value in [0, n). It
+	// returns an error if n is 0.
+	Uint64n(n uint64) (uint64, error)
+
+	// Int returns a non-negative random int.
+	Int() (int, error)
+
+	// Intn returns a uniformly distributed random value in [0, n). It panics if
+	// n <= 0.
+	Intn(n int) (int, error)
+
+	// Int63 returns a non-negative random int64, like math/rand.Int63.
+	//
+	// The values are drawn from an internal cache filled by a single generator
+	// request, which amortizes the rekey over many calls. This weakens forward
+	// secrecy: the values not yet returned are kept in memory, so a compromise
+	// of the process state reveals up to the next 64 values. The values already
+	// returned are wiped from the cache. The cache is discarded by the calls
+	// that reseed or replace the generator, like ForceReseed().
+	Int63() (int64, error)
 }
 
 // countedHash is a hash object that keeps track of the amount of data that was
@@ -80,30 +409,611 @@ type accumulator struct {
 	generator  generator                        // PRNG source, a rolling AES-256 in CTR mode
 	pools      [numPools]countedHash            // Entropy pools
 	temp       [numPools / 8 * sha256.Size]byte // Scratch space used in reseed to save a memory allocation.
+	audit      hash.Hash                        // Optional hash of all the data returned by Read
+	opts       Options                          // Immutable after construction
+	minPoolLen int                              // Block size of the pool hash, minPoolSize by default
+	running    bool                             // Set once NewFortuna has distributed the initial seed
+	hasEvents  [numPools]bool                   // Pools that received events since running was set
+	drains     [numPools]int                    // Number of times each pool was used in a reseed
+	seeded     bool                             // Reseeded from a pool with hasEvents set
+	clean      [numPools]bool                   // Pools emptied since the initial seed was distributed
+	fromEvents bool                             // Reseeded from a pool with both clean and hasEvents set
+	warned     bool                             // Set once the Options.WarnEarlyRead warning was logged
+	seededCh   chan struct{}                    // Closed when seeded is set
+	closed     bool                             // Set by Close
+	ctx        context.Context                  // Canceled by Close
+	cancel     func()                           // Cancels ctx
+	estimator  *estimator                       // Set when Options.EstimateEntropy is set
+	eventHash  func() hash.Hash                 // Options.EventHash or sha256.New
+	lastFresh  bool                             // Set by ReadFresh
+	lazy       *lazySeed                        // Set by NewFortunaLazy
+	eventLog   io.Writer                        // Set by StartEventLog
+	notify     chan int                         // Set by ReseedNotify
+	history    [reseedHistory]time.Time         // Ring buffer of the last reseeds, for ReseedRate
+	poolBuf    [32]byte                         // Pool choices drawn when Options.RandomPools is set
+	choices    []byte                           // Unused part of poolBuf
+	int63      int63Cache                       // Used by Int63
+
+	auditLock sync.Mutex     // Serializes the reads when audit is set
+	pending   sync.WaitGroup // Events added by AddRandomEvent not yet in a pool
+	eventSem  chan struct{}  // One item per goroutine started by AddRandomEvent
+	dropped   atomic.Uint64  // Events dropped by Middleware when saturated
+
+	queue     chan queuedEvent // Set when Options.Workers is set
+	queueLock sync.RWMutex     // Held for write when closing queue
+	workers   sync.WaitGroup   // Running workers
+}
+
+// Options controls the behavior of an instance created with
+// NewFortunaWithOptions. The zero value is the behavior of NewFortuna.
+type Options struct {
+	// BlockUntilSeeded makes Read() block until IsSeeded() is true, similar to
+	// /dev/random. This ensures no data is derived only from the initial seed.
+	// Use ReadContext() to bound the wait.
+	BlockUntilSeeded bool
+	// EstimateEntropy keeps a histogram of the data of each source for
+	// EntropyEstimate(). It adds a cost to each AddRandomEvent() call.
+	EstimateEntropy bool
+	// PoolHash constructs the hash used by each entropy pool. It defaults to
+	// SHA-256. The amount of data needed in pool 0 to reseed and the minimum
+	// seed length are derived from its block size. The generator uses
+	// GeneratorHash.
+	PoolHash func() hash.Hash
+	// MaxBytesPerRequest lowers the maximum amount of data returned by a single
+	// Read(), after which the generator is rekeyed. It must be a multiple of
+	// the AES block size and at most 1Mb, the default. Tune() can be used to
+	// find the fastest value on the current machine.
+	MaxBytesPerRequest int
+	// Logger receives diagnostics: a debug record on each reseed, a warning
+	// when the clock is detected to go backward and the warnings enabled by
+	// other options. Secret data is never logged.
+	Logger *slog.Logger
+	// MixCounter makes the generator hash its counter along the key and the
+	// seed on each reseed, so the new key depends on the full state of the
+	// generator. The output differs from the default.
+	MixCounter bool
+	// KeySize selects AES-128 with 16 or AES-256 with 32, the default. The
+	// generator's hash digest is truncated to the key size.
+	KeySize int
+	// GeneratorHash constructs the hash used by the generator to derive the
+	// new key on reseed. It defaults to SHA-256. Its size must be at least
+	// KeySize. It doesn't affect the maximum request size.
+	GeneratorHash func() hash.Hash
+	// ClockJumpReseed, when non-zero, forces a reseed when the wall clock moved
+	// forward by more than this duration since the last reseed, e.g. after a
+	// VM was suspended and resumed. The time delta is added to a pool first.
+	// It must be much larger than the reseed interval, e.g. a few minutes.
+	ClockJumpReseed time.Duration
+	// EventHash constructs the hash used to compress the events larger than 32
+	// bytes before they are written to a pool. It defaults to SHA-256.
+	// Versions before this option used SHA-1.
+	EventHash func() hash.Hash
+	// RequireRealEntropy makes Read() return ErrInsufficientEntropy until
+	// IsSeeded() is true, so no data derived only from the initial seed is
+	// ever returned. This differs from Fortuna's design, where the generator is
+	// always available once seeded and the pools only improve it over time; it
+	// is the fail-closed equivalent of BlockUntilSeeded.
+	RequireRealEntropy bool
+	// ContinuousTest compares each block of output with the previous one. Read
+	// returns ErrStuckGenerator, permanently, if two are identical. Such a
+	// failure is practically impossible unless the generator is broken.
+	ContinuousTest bool
+	// Workers, when set, makes AddRandomEvent() queue the events for this
+	// number of goroutines instead of starting one goroutine per event. This
+	// bounds the number of goroutines under bursty load. AddRandomEvent()
+	// blocks when the queue is full. The workers are stopped by Close().
+	Workers int
+	// MaxKeyAge, when non-zero, forces a reseed once the last reseed is older
+	// than this duration, even if pool 0 didn't accumulate enough entropy. This
+	// bounds the window during which a compromise of the generator state
+	// reveals the output. The reseed uses whatever entropy the pools have.
+	MaxKeyAge time.Duration
+	// ReadEvents adds the length and the time of each read to a pool as an
+	// event, since in a real server they carry some unpredictability. It is
+	// only supplementary entropy; the reads can be attacker-controlled.
+	ReadEvents bool
+	// MaxEventGoroutines bounds the number of goroutines started by
+	// AddRandomEvent() to process the events. Once reached, the events are
+	// processed inline, like AddRandomEventSync(). It defaults to
+	// defaultMaxEventGoroutines. It is ignored when Workers is set.
+	MaxEventGoroutines int
+	// RandomPools selects the pool receiving each event with bytes drawn from
+	// the generator instead of the round-robin prescribed by Fortuna, so an
+	// attacker who can time events can't steer them to a specific pool.
+	RandomPools bool
+	// WarnEarlyRead logs a warning to Logger the first time data is read while
+	// SeededFromEvents() is false, to notice secrets generated right after the
+	// construction. It is logged at most once per instance.
+	WarnEarlyRead bool
+	// CTRCompat makes the generator use cipher.NewCTR, which increments the
+	// counter as a big endian integer, instead of the little endian counter
+	// prescribed in p. 145, for byte compatibility with the ports built on
+	// NewCTR. The rekeying is unchanged. The two output streams are
+	// incompatible: the same seed yields a different output in each mode.
+	CTRCompat bool
 }
 
-func (a *accumulator) prepare() {
+// defaultMaxEventGoroutines is the default of Options.MaxEventGoroutines.
+const defaultMaxEventGoroutines = 256
+
+// prepare reseeds the generator if necessary before a read of n bytes. It
+// returns the audit hash, if any.
+func (a *accumulator) prepare(n int) (hash.Hash, error) {
 	now := time.Now()
 	a.lock.Lock()
 	defer a.lock.Unlock()
+	if a.closed {
+		return nil, ErrClosed
+	}
+	if err := a.lazyErr(); err != nil {
+		return nil, err
+	}
 	if a.lastReseed.After(now) {
 		// Clock rewinded. Reset lastReseed so the reseed will occur as soon as
 		// possible.
+		if a.opts.Logger != nil {
+			a.opts.Logger.Warn("fortuna: clock went backward", "last_reseed", a.lastReseed, "now", now)
+		}
 		a.lastReseed = time.Time{}
 	}
+	if a.opts.ClockJumpReseed != 0 && !a.lastReseed.IsZero() {
+		// Strip the monotonic clock reading since it doesn't advance while the
+		// machine is suspended.
+		if d := now.Round(0).Sub(a.lastReseed.Round(0)); d > a.opts.ClockJumpReseed {
+			if a.opts.Logger != nil {
+				a.opts.Logger.Warn("fortuna: clock jumped forward", "delta", d)
+			}
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(d))
+			a.addEvent(a.frameEvent(0, [][]byte{b[:]}))
+			a.reseed(now)
+		}
+	}
 	// Only reseed when enough entropy accumulated and a minimum interval occured
 	// since the last reseed.
-	if a.pools[0].length >= minPoolSize && now.After(a.lastReseed.Add(reseedInterval)) {
+	if a.pools[0].length >= a.minPoolLen && now.After(a.lastReseed.Add(reseedInterval)) {
 		a.reseed(now)
 	}
+	if a.opts.MaxKeyAge != 0 && now.Sub(a.lastReseed) > a.opts.MaxKeyAge {
+		a.reseed(now)
+	}
+	if a.opts.ReadEvents && n != 0 {
+		// Added after the reseed check so it only affects the next reseed.
+		var b [16]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(n))
+		binary.LittleEndian.PutUint64(b[8:], uint64(now.UnixNano()))
+		a.addEvent(a.frameEvent(0, [][]byte{b[:]}))
+	}
+	if a.opts.RequireRealEntropy && !a.seeded {
+		return nil, ErrInsufficientEntropy
+	}
+	if a.opts.WarnEarlyRead && !a.warned && !a.fromEvents && n != 0 && a.opts.Logger != nil {
+		a.warned = true
+		a.opts.Logger.Warn("fortuna: read before being seeded from events", "num_reseed", a.numReseed)
+	}
+	return a.audit, nil
 }
 
 // Read reads random data up to 1Mb, reseeding the accumulator if necessary.
 func (a *accumulator) Read(data []byte) (int, error) {
-	a.prepare()
-	// Return PRNG data from the generator. The generator is thread-safe so no
-	// need to keep the accumulator lock.
-	return a.generator.Read(data)
+	return a.ReadContext(context.Background(), data)
+}
+
+func (a *accumulator) ReadContext(ctx context.Context, data []byte) (int, error) {
+	if a.opts.BlockUntilSeeded {
+		if err := a.waitSeeded(ctx); err != nil {
+			return 0, err
+		}
+	}
+	audit, err := a.prepare(len(data))
+	if err != nil {
+		return 0, err
+	}
+	return a.read(audit, data)
+}
+
+// read reads from the generator and writes the data to audit, if set.
+func (a *accumulator) read(audit hash.Hash, data []byte) (int, error) {
+	if audit == nil {
+		// Return PRNG data from the generator. The generator is thread-safe so no
+		// need to keep the accumulator lock.
+		return a.generator.Read(data)
+	}
+	a.auditLock.Lock()
+	defer a.auditLock.Unlock()
+	n, err := a.generator.Read(data)
+	_, _ = audit.Write(data[:n])
+	return n, err
+}
+
+func (a *accumulator) ReadFresh(p []byte) (int, error) {
+	if a.opts.BlockUntilSeeded {
+		if err := a.waitSeeded(context.Background()); err != nil {
+			return 0, err
+		}
+	}
+	a.lock.Lock()
+	if a.closed {
+		a.lock.Unlock()
+		return 0, ErrClosed
+	}
+	if err := a.lazyErr(); err != nil {
+		a.lock.Unlock()
+		return 0, err
+	}
+	a.lastFresh = a.pools[0].length >= a.minPoolLen
+	if a.lastFresh {
+		a.reseed(time.Now())
+	}
+	if a.opts.RequireRealEntropy && !a.seeded {
+		a.lock.Unlock()
+		return 0, ErrInsufficientEntropy
+	}
+	audit := a.audit
+	a.lock.Unlock()
+	return a.read(audit, p)
+}
+
+func (a *accumulator) LastReadWasFresh() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lastFresh
+}
+
+func (a *accumulator) ReadAtMost(p []byte) (int, error) {
+	return a.ReadContext(context.Background(), p)
+}
+
+func (a *accumulator) ReadInto(buf []byte) error {
+	for len(buf) != 0 {
+		n, err := a.ReadContext(context.Background(), buf)
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+func (a *accumulator) FillLarge(p []byte) error {
+	_, err := a.ReadVectored(p)
+	return err
+}
+
+func (a *accumulator) StreamToWithDigest(w io.Writer, n int64, h hash.Hash) (int64, []byte, error) {
+	h.Reset()
+	buf := make([]byte, 32*1024)
+	defer Wipe(buf)
+	c, err := io.CopyBuffer(io.MultiWriter(w, h), Limit(a, n), buf)
+	return c, h.Sum(nil), err
+}
+
+func (a *accumulator) StreamToContext(ctx context.Context, w io.Writer, n int64) (int64, error) {
+	buf := make([]byte, 32*1024)
+	defer Wipe(buf)
+	var written int64
+	for written < n {
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		b := buf
+		if l := n - written; l < int64(len(b)) {
+			b = b[:l]
+		}
+		if _, err := a.ReadContext(ctx, b); err != nil {
+			return written, err
+		}
+		m, err := w.Write(b)
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		if m != len(b) {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
+
+func (a *accumulator) ReadVectored(bufs ...[]byte) (int, error) {
+	if a.opts.BlockUntilSeeded {
+		if err := a.waitSeeded(context.Background()); err != nil {
+			return 0, err
+		}
+	}
+	l := 0
+	for _, b := range bufs {
+		l += len(b)
+	}
+	audit, err := a.prepare(l)
+	if err != nil {
+		return 0, err
+	}
+	if audit == nil {
+		return a.generator.readVectored(bufs)
+	}
+	a.auditLock.Lock()
+	defer a.auditLock.Unlock()
+	n, err := a.generator.readVectored(bufs)
+	if err == nil {
+		for _, b := range bufs {
+			_, _ = audit.Write(b)
+		}
+	}
+	return n, err
+}
+
+// waitSeeded blocks until the accumulator is seeded or ctx is done.
+//
+// The pools are regularly checked for a reseed opportunity so a blocked reader
+// doesn't depend on other readers to make progress.
+func (a *accumulator) waitSeeded(ctx context.Context) error {
+	t := time.NewTimer(reseedInterval)
+	defer t.Stop()
+	for {
+		if _, err := a.prepare(0); err != nil && err != ErrInsufficientEntropy {
+			return err
+		}
+		select {
+		case <-a.seededCh:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			t.Reset(reseedInterval)
+		}
+	}
+}
+
+func (a *accumulator) SeededFromEvents() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.fromEvents
+}
+
+func (a *accumulator) IsSeeded() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.seeded
+}
+
+func (a *accumulator) LastReseed() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lastReseed
+}
+
+func (a *accumulator) NextReseed() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lastReseed.Add(reseedInterval)
+}
+
+func (a *accumulator) ReseedRate(window time.Duration) float64 {
+	return a.reseedRate(time.Now(), window)
+}
+
+// reseedRate returns the reseeds per second in the window ending at now.
+func (a *accumulator) reseedRate(now time.Time, window time.Duration) float64 {
+	if window <= 0 {
+		return 0
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	start := now.Add(-window)
+	n := 0
+	for _, t := range a.history {
+		if !t.IsZero() && t.After(start) && !t.After(now) {
+			n++
+		}
+	}
+	return float64(n) / window.Seconds()
+}
+
+func (a *accumulator) ReseedNotify() <-chan int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.notify == nil {
+		a.notify = make(chan int, 1)
+	}
+	return a.notify
+}
+
+func (a *accumulator) ForceReseed() {
+	// Deferred first so it runs after the unlock.
+	defer a.int63.wipe()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.closed {
+		a.reseed(time.Now())
+	}
+}
+
+func (a *accumulator) ReseedDrainAll() {
+	defer a.int63.wipe()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return
+	}
+	a.numReseed++
+	var seed []byte
+	fresh := false
+	pools := 0
+	for i := range a.pools {
+		if a.pools[i].length == 0 {
+			continue
+		}
+		pools++
+		seed = a.pools[i].Sum(seed)
+		a.pools[i].Reset()
+		a.drains[i]++
+		fresh = fresh || a.hasEvents[i]
+		a.fromEvents = a.fromEvents || (a.clean[i] && a.hasEvents[i])
+		a.hasEvents[i] = false
+		a.clean[i] = true
+	}
+	a.recordReseed(time.Now(), pools, fresh)
+	_, _ = a.generator.Write(seed)
+	Wipe(seed)
+}
+
+func (a *accumulator) PoolDrainCounts() [numPools]int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.drains
+}
+
+func (a *accumulator) ResetPools() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for i := range a.pools {
+		a.pools[i].Reset()
+		a.hasEvents[i] = false
+		a.clean[i] = true
+	}
+	a.numReseed = 0
+	a.nextPool = 0
+}
+
+func (a *accumulator) Close() error {
+	a.lock.Lock()
+	if a.closed {
+		a.lock.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.cancel()
+	for i := range a.pools {
+		a.pools[i].Reset()
+	}
+	a.generator.lock.Lock()
+	Wipe(a.generator.key)
+	Wipe(a.generator.lastBlock)
+	a.generator.initialized = false
+	a.generator.lock.Unlock()
+	Wipe(a.poolBuf[:])
+	a.choices = nil
+	a.lock.Unlock()
+	a.int63.wipe()
+	if a.queue != nil {
+		// The workers need the lock to drain the queue.
+		a.queueLock.Lock()
+		close(a.queue)
+		a.queueLock.Unlock()
+		a.workers.Wait()
+	}
+	return nil
+}
+
+// accumulatorGenerator is the handle returned by Generator().
+type accumulatorGenerator struct {
+	a *accumulator
+}
+
+func (g accumulatorGenerator) Read(data []byte) (int, error) {
+	return g.a.Read(data)
+}
+
+func (g accumulatorGenerator) Write(data []byte) (int, error) {
+	defer g.a.int63.wipe()
+	g.a.lock.Lock()
+	defer g.a.lock.Unlock()
+	if g.a.closed {
+		return 0, ErrClosed
+	}
+	return g.a.generator.Write(data)
+}
+
+func (a *accumulator) SetGenerator(g io.ReadWriter) error {
+	n, ok := g.(*generator)
+	if !ok {
+		return errors.New("SetGenerator requires a generator returned by NewGenerator")
+	}
+	defer a.int63.wipe()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if !n.initialized {
+		return errors.New("Generator is not seeded")
+	}
+	if m := a.opts.MaxBytesPerRequest; m > n.maxBytesPerRequest {
+		return fmt.Errorf("MaxBytesPerRequest %d is too large for the generator, must be up to %d", m, n.maxBytesPerRequest)
+	}
+	// Readers hold the generator lock for the whole request.
+	a.generator.lock.Lock()
+	defer a.generator.lock.Unlock()
+	Wipe(a.generator.key)
+	a.generator.key = append([]byte{}, n.key...)
+	copy(a.generator.counter, n.counter)
+	if a.opts.MaxBytesPerRequest == 0 {
+		a.generator.maxBytesPerRequest = n.maxBytesPerRequest
+	}
+	a.generator.h = n.h
+	a.generator.temp = make([]byte, len(n.temp))
+	a.generator.initialized = true
+	Wipe(a.generator.lastBlock)
+	a.generator.lastBlock = nil
+	return nil
+}
+
+func (a *accumulator) SecurityBits() int {
+	return a.generator.SecurityBits()
+}
+
+func (a *accumulator) BytesUntilRekey() int {
+	return a.generator.BytesUntilRekey()
+}
+
+func (a *accumulator) Generator() io.ReadWriter {
+	return accumulatorGenerator{a}
+}
+
+func (a *accumulator) SetAuditHash(h hash.Hash) {
+	a.auditLock.Lock()
+	defer a.auditLock.Unlock()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.audit = h
+}
+
+func (a *accumulator) AuditDigest() []byte {
+	a.auditLock.Lock()
+	defer a.auditLock.Unlock()
+	a.lock.Lock()
+	h := a.audit
+	a.lock.Unlock()
+	if h == nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// recordReseed does the bookkeeping of a reseed that drained the given number
+// of pools, after numReseed was incremented. fresh is true if one of them had
+// events.
+//
+// Lock must be held by the caller.
+func (a *accumulator) recordReseed(now time.Time, pools int, fresh bool) {
+	a.lastReseed = now
+	a.history[a.numReseed%reseedHistory] = now
+	if a.opts.Logger != nil {
+		a.opts.Logger.Debug("fortuna: reseed", "count", a.numReseed, "pools", pools)
+	}
+	if fresh && !a.seeded {
+		a.seeded = true
+		close(a.seededCh)
+	}
+	if a.notify != nil {
+		select {
+		case a.notify <- a.numReseed:
+		default:
+		}
+	}
 }
 
 // reseed uses entropy from the pools to reseed the generator.
@@ -113,95 +1023,676 @@ func (a *accumulator) Read(data []byte) (int, error) {
 func (a *accumulator) reseed(now time.Time) {
 	// Seeding happens at a minimum interval of reseedInterval so it's not a perf
 	// critical.
-	a.lastReseed = now
 	a.numReseed++
 	seed := a.temp[:0]
 
 	mask := 0
+	fresh := false
 	// Pool P_i is included if 2**i is a divisor of a.numReseed
 	for i := 0; i < numPools && a.numReseed&mask == 0; i++ {
 		seed = a.pools[i].Sum(seed)
 		// Reset the entropy pool after extracting entropy from it so this
 		// entropy is not used again.
 		a.pools[i].Reset()
+		a.drains[i]++
+		fresh = fresh || a.hasEvents[i]
+		a.fromEvents = a.fromEvents || (a.clean[i] && a.hasEvents[i])
+		a.hasEvents[i] = false
+		a.clean[i] = true
 		mask <<= 1
 		mask |= 1
 	}
+	a.recordReseed(now, len(seed)/a.pools[0].Size(), fresh)
 
 	// Double SHA256 the key plus the seed. In practice, the sum is at least
 	// minPoolSize.
 	_, _ = a.generator.Write(seed)
+	Wipe(seed)
+	// seed was reallocated if it outgrew temp, which keeps the first digests.
+	Wipe(a.temp[:])
+}
+
+func (a *accumulator) AddRandomEvent(source byte, data ...[]byte) {
+	a.addRandomEvent(source, data, false)
 }
 
-func (a *accumulator) AddRandomEvent(source byte, data []byte) {
+// addRandomEvent implements AddRandomEvent. When noWait is true, the event is
+// dropped and counted in dropped instead of blocking when the accumulator is
+// saturated.
+func (a *accumulator) addRandomEvent(source byte, data [][]byte, noWait bool) {
+	if a.lazy != nil {
+		a.lazy.start(a)
+	}
 	// This function must return very quickly so the data is first copied and the
 	// actual processing is done in a goroutine. This removes the potential
-	// undesired serialization of the caller due to the accumulator's lock.
+	// undesired serialization of the caller due to the accumulator's lock,
+	// except when the accumulator is saturated.
+	if a.estimator != nil {
+		a.estimator.add(source, data)
+	}
+	if a.queue != nil {
+		a.enqueue(source, data, noWait)
+		return
+	}
+	buffer := a.frameEvent(source, data)
+	if buffer == nil {
+		return
+	}
+	select {
+	case a.eventSem <- struct{}{}:
+	default:
+		// Too many goroutines already.
+		if noWait {
+			a.dropped.Add(1)
+			return
+		}
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.addEvent(buffer)
+		return
+	}
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		defer func() { <-a.eventSem }()
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.addEvent(buffer)
+	}()
+}
+
+func (a *accumulator) AddTiming(source byte) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+	a.AddRandomEvent(source, b[:])
+}
+
+func (a *accumulator) Flush() {
+	a.pending.Wait()
+}
+
+// queuedEvent is an event waiting in the queue of the workers.
+type queuedEvent struct {
+	source byte
+	data   []byte
+}
+
+// enqueue copies the event in the workers queue. It blocks when the queue is
+// full, unless noWait is true, in which case the event is dropped.
+func (a *accumulator) enqueue(source byte, data [][]byte, noWait bool) {
+	l := 0
+	for _, d := range data {
+		l += len(d)
+	}
+	if l == 0 {
+		return
+	}
+	e := queuedEvent{source, make([]byte, 0, l)}
+	for _, d := range data {
+		e.data = append(e.data, d...)
+	}
+	a.queueLock.RLock()
+	defer a.queueLock.RUnlock()
+	if a.ctx.Err() != nil {
+		return
+	}
+	a.pending.Add(1)
+	if noWait {
+		select {
+		case a.queue <- e:
+		default:
+			a.pending.Done()
+			a.dropped.Add(1)
+		}
+		return
+	}
+	select {
+	case a.queue <- e:
+	case <-a.ctx.Done():
+		a.pending.Done()
+	}
+}
+
+// work frames and adds the events in the queue to the pools until the queue
+// is closed.
+func (a *accumulator) work() {
+	defer a.workers.Done()
+	for e := range a.queue {
+		// Hash the large events without the lock.
+		buffer := a.frameEvent(e.source, [][]byte{e.data})
+		Wipe(e.data)
+		a.lock.Lock()
+		a.addEvent(buffer)
+		a.lock.Unlock()
+		a.pending.Done()
+	}
+}
+
+func (a *accumulator) AddRandomEventErr(source byte, data ...[]byte) error {
+	a.lock.Lock()
+	closed := a.closed
+	a.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	a.AddRandomEvent(source, data...)
+	return nil
+}
+
+func (a *accumulator) AddRandomEventSync(source byte, data ...[]byte) {
+	if a.estimator != nil {
+		a.estimator.add(source, data)
+	}
+	buffer := a.frameEvent(source, data)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.addEvent(buffer)
+}
+
+func (a *accumulator) EntropyEstimate(source byte) float64 {
+	if a.estimator == nil {
+		return 0
+	}
+	return a.estimator.estimate(source)
+}
+
+func (a *accumulator) ReseedFromSystem() error {
+	var b [32]byte
+	defer Wipe(b[:])
+	werr := readSystemRand(b[:])
+	defer a.int63.wipe()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	// Spread the OS entropy over a few pools, like any other source would.
+	for i := 0; i < len(b); i += 8 {
+		a.addEvent(a.frameEvent(0, [][]byte{b[i : i+8]}))
+	}
+	a.reseed(time.Now())
+	return werr
+}
+
+func (a *accumulator) SeedFromDevice(path string, n int) error {
+	if n <= 0 {
+		return errors.New("invalid argument to SeedFromDevice")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b := make([]byte, n)
+	defer Wipe(b)
+	// Devices may return less data than requested per read.
+	if _, err := io.ReadFull(f, b); err != nil {
+		return fmt.Errorf("reading %d bytes from %s: %w", n, path, err)
+	}
+	defer a.int63.wipe()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	for len(b) != 0 {
+		l := len(b)
+		if l > 32 {
+			l = 32
+		}
+		a.addEvent(a.frameEvent(0, [][]byte{b[:l]}))
+		b = b[l:]
+	}
+	a.reseed(time.Now())
+	return nil
+}
+
+func (a *accumulator) ReseedExternal(g io.Writer) error {
+	var b [32]byte
+	defer Wipe(b[:])
+	if _, err := a.ReadFresh(b[:]); err != nil {
+		return err
+	}
+	_, err := g.Write(b[:])
+	return err
+}
+
+func (a *accumulator) PrimePools(entropy io.Reader) error {
+	buf := make([]byte, 32*numPools)
+	defer Wipe(buf)
+	for {
+		a.lock.Lock()
+		closed := a.closed
+		primed := true
+		for i := range a.pools {
+			primed = primed && a.pools[i].length >= a.minPoolLen
+		}
+		a.lock.Unlock()
+		if closed {
+			return ErrClosed
+		}
+		if primed {
+			return nil
+		}
+		// Don't hold the lock while reading.
+		if _, err := io.ReadFull(entropy, buf); err != nil {
+			return fmt.Errorf("priming the pools: %w", err)
+		}
+		for i := 0; i < len(buf); i += 32 {
+			a.AddRandomEventSync(0, buf[i:i+32])
+		}
+	}
+}
+
+// frameEvent returns the data to be written to a pool for an event made of
+// the concatenation of data. Events larger than 32 bytes are hashed with the
+// event hash.
+//
+// The data is copied so the caller can reuse its buffers. It returns nil for
+// an empty event.
+func (a *accumulator) frameEvent(source byte, data [][]byte) []byte {
+	l := 0
+	for _, d := range data {
+		l += len(d)
+	}
+	if l == 0 {
+		return nil
+	}
 	var buffer []byte
-	if len(data) > 32 {
-		h := sha1.New()
-		_, _ = h.Write(data)
+	if l > 32 {
+		h := a.eventHash()
+		for _, d := range data {
+			_, _ = h.Write(d)
+		}
 		buffer = h.Sum(make([]byte, 2, 2+h.Size()))
 	} else {
-		buffer = append(make([]byte, 2, len(data)+2), data...)
+		buffer = make([]byte, 2, l+2)
+		for _, d := range data {
+			buffer = append(buffer, d...)
+		}
 	}
 	buffer[0] = source
-	buffer[1] = byte(len(data))
+	buffer[1] = byte(l)
+	return buffer
+}
 
-	go func() {
-		a.lock.Lock()
-		defer a.lock.Unlock()
+// addEvent writes a framed event to the next pool.
+//
+// This method must be called with the lock held. The event is dropped if the
+// accumulator is closed or if it is empty.
+func (a *accumulator) addEvent(buffer []byte) {
+	if a.closed || len(buffer) == 0 {
+		return
+	}
+	p := a.nextPool
+	if a.opts.RandomPools {
+		p = a.randomPool()
+	}
+	a.addEventTo(p, buffer)
+	a.nextPool = (a.nextPool + 1) % numPools
+}
 
-		_, _ = a.pools[a.nextPool].Write(buffer)
-		a.nextPool = (a.nextPool + 1) % numPools
-	}()
+// addEventTo is the same as addEvent but writes to pool p and doesn't change
+// nextPool.
+//
+// This method must be called with the lock held.
+func (a *accumulator) addEventTo(p int, buffer []byte) {
+	if a.closed || len(buffer) == 0 {
+		return
+	}
+	if a.eventLog != nil {
+		a.logEvent(buffer)
+	}
+	_, _ = a.pools[p].Write(buffer)
+	if a.running {
+		a.hasEvents[p] = true
+	}
 }
 
-// NewFortuna returns a new Fortuna instance seeded using seed.
-// It is up to the caller to ensure that enough entropy is added to it. The
-// io.Reader interface is to be used to read random data.
+// randomPool returns a pool drawn from the generator. It returns nextPool if
+// the generator is not seeded yet.
+//
+// This method must be called with the lock held.
+func (a *accumulator) randomPool() int {
+	if len(a.choices) == 0 {
+		if _, err := a.generator.Read(a.poolBuf[:]); err != nil {
+			return a.nextPool
+		}
+		a.choices = a.poolBuf[:]
+	}
+	// 256 is a multiple of numPools so there's no bias.
+	p := int(a.choices[0]) % numPools
+	a.choices[0] = 0
+	a.choices = a.choices[1:]
+	return p
+}
+
+// newAccumulator returns an accumulator with empty pools and an unseeded
+// generator.
+func newAccumulator(opts Options) *accumulator {
+	a := &accumulator{opts: opts, seededCh: make(chan struct{})}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	m := opts.MaxEventGoroutines
+	if m <= 0 {
+		m = defaultMaxEventGoroutines
+	}
+	a.eventSem = make(chan struct{}, m)
+	a.generator.init(nil, nil)
+	for i := range a.pools {
+		a.pools[i].Hash = a.newPoolHash()
+	}
+	a.minPoolLen = a.pools[0].BlockSize()
+	a.eventHash = opts.EventHash
+	if a.eventHash == nil {
+		a.eventHash = sha256.New
+	}
+	return a
+}
+
+// newPoolHash returns a hash for an entropy pool.
+func (a *accumulator) newPoolHash() hash.Hash {
+	if a.opts.PoolHash != nil {
+		return a.opts.PoolHash()
+	}
+	return sha256.New()
+}
+
+// seedDistribution returns the number of bytes of a seed of seedLen bytes
+// that NewFortuna writes to each pool. Pool 0 receives the first
+// minPoolLen-16 bytes and the rest of the seed, starting at minPoolLen+16, is
+// split across the remaining pools. The 32 bytes in between are not used.
+//
+// When the rest is not a multiple of numPools-1, the first pools receive one
+// more byte.
+func seedDistribution(seedLen, minPoolLen int) []int {
+	out := make([]int, numPools)
+	out[0] = minPoolLen - 16
+	left := seedLen - minPoolLen - 16
+	for i := 1; i < numPools; i++ {
+		remaining := numPools - i
+		out[i] = (left + remaining - 1) / remaining
+		left -= out[i]
+	}
+	return out
+}
+
+// BuildSeed returns a seed of MinSeedSize bytes for NewFortuna derived from
+// all the inputs, e.g. the hostname, the process ID and a secret from the
+// configuration.
+//
+// Each input is prefixed with its length so the inputs can't be shifted
+// between each other, then all of them are hashed with DoubleHashXOF. The
+// output only has as much entropy as all the inputs combined.
+func BuildSeed(inputs ...[]byte) []byte {
+	data := make([][]byte, 0, 2*len(inputs))
+	for _, in := range inputs {
+		var l [8]byte
+		binary.LittleEndian.PutUint64(l[:], uint64(len(in)))
+		data = append(data, l[:], in)
+	}
+	return DoubleHashXOF(sha256.New, MinSeedSize, data...)
+}
+
+// NewFortuna returns a new Fortuna instance seeded using seed, which must be
+// at least MinSeedSize bytes. It is up to the caller to ensure that enough
+// entropy is added to it. The io.Reader interface is to be used to read random
+// data.
 //
 // The resulting object is thread safe.
 func NewFortuna(seed []byte) (Fortuna, error) {
-	// Described as InitializePRNG p.153
-	//
-	// 2*minPoolSize guarantees that the first pool is correctly initialized and
-	// the remaining ones have at least a little bit of entropy.
-	if len(seed) < 2*minPoolSize {
-		return nil, fmt.Errorf("initial seed is too short, provide at least %d bytes", 2*minPoolSize)
+	return NewFortunaWithOptions(seed, Options{})
+}
+
+// NewFortunaAutoSeed returns a new Fortuna instance seeded from crypto/rand.
+//
+// If crypto/rand fails, e.g. early at boot or in a locked-down sandbox, the
+// seed is derived with BuildSeed from the time, the process IDs, the hostname
+// and memory addresses. This is a last resort with a much lower security: the
+// instance is returned along ErrWeakSeed wrapping the crypto/rand error. The
+// caller decides whether to use it, ideally after adding real entropy with
+// AddRandomEvent().
+func NewFortunaAutoSeed() (Fortuna, error) {
+	seed := make([]byte, MinSeedSize)
+	defer Wipe(seed)
+	rerr := readSystemRand(seed)
+	f, err := NewFortuna(seed)
+	if err != nil {
+		return nil, err
 	}
-	a := &accumulator{
-		generator: newGenerator(nil, nil),
+	return f, rerr
+}
+
+// readSystemRand fills b, which must be at most MinSeedSize bytes, from
+// crypto/rand. If crypto/rand fails, b is filled from weakSeed instead and the
+// returned error wraps ErrWeakSeed.
+func readSystemRand(b []byte) error {
+	_, err := io.ReadFull(systemRand, b)
+	if err == nil {
+		return nil
 	}
-	for i := range a.pools {
-		a.pools[i].Hash = sha256.New()
+	w := weakSeed()
+	defer Wipe(w)
+	copy(b, w)
+	return fmt.Errorf("%w: %v", ErrWeakSeed, err)
+}
+
+// weakSeed returns a seed from the inputs available when crypto/rand is not.
+func weakSeed() []byte {
+	var b [5 * 8]byte
+	now := time.Now()
+	binary.LittleEndian.PutUint64(b[0:], uint64(now.UnixNano()))
+	binary.LittleEndian.PutUint64(b[8:], uint64(os.Getpid()))
+	binary.LittleEndian.PutUint64(b[16:], uint64(os.Getppid()))
+	// Addresses of variables, randomized by ASLR.
+	binary.LittleEndian.PutUint64(b[24:], uint64(uintptr(unsafe.Pointer(&now))))
+	binary.LittleEndian.PutUint64(b[32:], uint64(uintptr(unsafe.Pointer(new(byte)))))
+	host, _ := os.Hostname()
+	return BuildSeed(b[:], []byte(host))
+}
+
+// NewFortunaWithOptions is the same as NewFortuna with non-default options.
+func NewFortunaWithOptions(seed []byte, opts Options) (Fortuna, error) {
+	a := newAccumulator(opts)
+	if m := opts.MaxBytesPerRequest; m != 0 {
+		if m < 0 || m > a.generator.maxBytesPerRequest || m%aes.BlockSize != 0 {
+			return nil, fmt.Errorf("invalid MaxBytesPerRequest %d, must be a multiple of %d up to %d", m, aes.BlockSize, a.generator.maxBytesPerRequest)
+		}
+		a.generator.maxBytesPerRequest = m
+	}
+	a.generator.mixCounter = opts.MixCounter
+	a.generator.bigEndian = opts.CTRCompat
+	a.generator.continuousTest = opts.ContinuousTest
+	switch opts.KeySize {
+	case 0:
+	case 16, 32:
+		a.generator.setKeySize(opts.KeySize)
+	default:
+		return nil, fmt.Errorf("invalid KeySize %d, must be 16 or 32", opts.KeySize)
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("invalid Workers %d", opts.Workers)
+	}
+	if opts.MaxEventGoroutines < 0 {
+		return nil, fmt.Errorf("invalid MaxEventGoroutines %d", opts.MaxEventGoroutines)
+	}
+	if err := checkHash(a.pools[0].Hash); err != nil {
+		return nil, fmt.Errorf("invalid PoolHash: %w", err)
+	}
+	if err := checkHash(a.eventHash()); err != nil {
+		return nil, fmt.Errorf("invalid EventHash: %w", err)
+	}
+	if opts.GeneratorHash != nil {
+		h := opts.GeneratorHash()
+		if err := checkHash(h); err != nil {
+			return nil, fmt.Errorf("invalid GeneratorHash: %w", err)
+		}
+		if h.Size() < len(a.generator.key) {
+			return nil, fmt.Errorf("GeneratorHash size %d is smaller than the key size %d", h.Size(), len(a.generator.key))
+		}
+		a.generator.h = h
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err := a.distributeSeed(seed); err != nil {
+		return nil, err
+	}
+	if opts.Workers > 0 {
+		a.queue = make(chan queuedEvent, 256*opts.Workers)
+		a.workers.Add(opts.Workers)
+		for i := 0; i < opts.Workers; i++ {
+			go a.work()
+		}
+	}
+	// Only the events added by the users are of interest to the estimator.
+	if opts.EstimateEntropy {
+		a.estimator = &estimator{}
+	}
+	return a, nil
+}
+
+// distributeSeed writes seed to the pools and reseeds the generator.
+//
+// Described as InitializePRNG p.153. This method must be called with the lock
+// held.
+func (a *accumulator) distributeSeed(seed []byte) error {
+	// 2*minPoolSize guarantees that the first pool is correctly initialized and
+	// the remaining ones have at least a little bit of entropy.
+	if len(seed) < 2*a.minPoolLen {
+		return fmt.Errorf("initial seed is too short, provide at least %d bytes", 2*a.minPoolLen)
 	}
 
 	// Write the initial minPoolSize bytes to pool 0, otherwise the generator
 	// will not be correctly reseeded on the initial accumulator.Read() is called.
-	// Writes the timestamp to pool 0. This means only 64-16 = 48 bytes of the
-	// seed are used in the initial key. The rest of the seed is distributed
-	// across the remaining entropy pools.
-	pool0 := [minPoolSize]byte{}
+	// The first 16 bytes are left zero; no timestamp is written so the initial
+	// generator state only depends on the seed. This means only 64-16 = 48
+	// bytes of the seed are used in the initial key. The rest of the seed is
+	// distributed across the remaining entropy pools.
+	pool0 := make([]byte, a.minPoolLen)
 	// Fill the remaining of pool0 with the first part of seed.
 	copy(pool0[16:], seed)
-	a.AddRandomEvent(0, pool0[:])
+	// The pools are explicit since events may have moved nextPool already, e.g.
+	// with NewFortunaLazy.
+	a.addEventTo(0, a.frameEvent(0, [][]byte{pool0}))
+	Wipe(pool0)
 
 	// Distribute the remaining seed across the remaining pools.
-	seed = seed[minPoolSize+16:]
-	// When len(seed)%(numPools-1) != 0, distributes more bytes to the first
-	// pools.
-	for i := 1; i < numPools; i++ {
-		remaining := numPools - i
-		perPool := (len(seed) + remaining - 1) / remaining
-		a.AddRandomEvent(byte(i), seed[:perPool])
+	dist := seedDistribution(len(seed), a.minPoolLen)
+	seed = seed[a.minPoolLen+16:]
+	for i, perPool := range dist[1:] {
+		a.addEventTo(i+1, a.frameEvent(byte(i+1), [][]byte{seed[:perPool]}))
 		seed = seed[perPool:]
 	}
-	// It's now safe to reseed the generator. This adds a very minimalist amount
-	// of non-determinism in the accumulator. This is not sufficient for a
-	// crypto-level RNG.
+	// It's now safe to reseed the generator. The time is only recorded as the
+	// last reseed and doesn't affect the generator state.
+	a.reseed(time.Now())
+	a.running = true
+	return nil
+}
+
+func (a *accumulator) ReseedFromScratch(seed []byte) error {
+	// Wait for the pending events so they don't land in the new pools.
+	a.Flush()
+	err := a.reseedFromScratch(seed)
+	// The cache takes the accumulator lock when refilled so it is wiped
+	// without holding it, like in Close.
+	a.int63.wipe()
+	return err
+}
+
+func (a *accumulator) reseedFromScratch(seed []byte) error {
 	a.lock.Lock()
 	defer a.lock.Unlock()
-	a.reseed(time.Now())
+	if a.closed {
+		return ErrClosed
+	}
+	if len(seed) < 2*a.minPoolLen {
+		return fmt.Errorf("initial seed is too short, provide at least %d bytes", 2*a.minPoolLen)
+	}
+	for i := range a.pools {
+		a.pools[i].Reset()
+		a.hasEvents[i] = false
+		a.clean[i] = false
+		a.drains[i] = 0
+	}
+	a.fromEvents = false
+	a.numReseed = 0
+	a.nextPool = 0
+	a.lastReseed = time.Time{}
+	a.lastFresh = false
+	a.running = false
+	a.warned = false
+	a.history = [reseedHistory]time.Time{}
+	Wipe(a.poolBuf[:])
+	a.choices = nil
+	if a.seeded {
+		a.seeded = false
+		a.seededCh = make(chan struct{})
+	}
+	a.generator.lock.Lock()
+	Wipe(a.generator.key)
+	Wipe(a.generator.counter)
+	Wipe(a.generator.lastBlock)
+	a.generator.lastBlock = nil
+	a.generator.stuck = false
+	a.generator.generated = 0
+	a.generator.initialized = false
+	a.generator.lock.Unlock()
+	return a.distributeSeed(seed)
+}
+
+// lazySeed fetches the seed of an instance created with NewFortunaLazy.
+type lazySeed struct {
+	once sync.Once
+	fn   func() ([]byte, error)
+	done bool  // Protected by the accumulator lock
+	err  error // Protected by the accumulator lock
+}
+
+// start calls fn in a goroutine the first time it is called.
+func (l *lazySeed) start(a *accumulator) {
+	l.once.Do(func() {
+		go func() {
+			seed, err := l.fn()
+			a.lock.Lock()
+			defer a.lock.Unlock()
+			if err == nil && !a.closed {
+				err = a.distributeSeed(seed)
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to get the seed: %w", err)
+			}
+			l.done, l.err = true, err
+			Wipe(seed)
+		}()
+	})
+}
+
+// lazyErr starts fetching the lazy seed if needed and returns ErrNotSeeded
+// until the seed is distributed, or the error from the seed function.
+//
+// This method must be called with the lock held.
+func (a *accumulator) lazyErr() error {
+	if a.lazy == nil {
+		return nil
+	}
+	a.lazy.start(a)
+	if !a.lazy.done {
+		return ErrNotSeeded
+	}
+	return a.lazy.err
+}
+
+// NewFortunaLazy returns a new Fortuna instance whose seed is returned by
+// seedFunc, e.g. from a secrets manager, as if it was passed to NewFortuna.
+//
+// seedFunc is called once in a goroutine on the first Read() or
+// AddRandomEvent(). Reads return ErrNotSeeded until it returns. If it fails or
+// the seed is too short, reads return this error.
+func NewFortunaLazy(seedFunc func() ([]byte, error)) (Fortuna, error) {
+	if seedFunc == nil {
+		return nil, errors.New("seedFunc is required")
+	}
+	a := newAccumulator(Options{})
+	a.lazy = &lazySeed{fn: seedFunc}
 	return a, nil
 }
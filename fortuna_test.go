@@ -5,9 +5,29 @@
 package fortuna
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 // Base64 encoding of bytes from 00 to 7F.
@@ -34,68 +54,1646 @@ func TestLimit(t *testing.T) {
 	read(t, prng, data, maxBytesPerRequest)
 }
 
+func TestReadAtMost(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	data := make([]byte, 2<<20)
+	if n, err := prng.ReadAtMost(data); n != 1<<20 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if !bytes.Equal(data[1<<20:], make([]byte, 1<<20)) {
+		t.Fatal("Wrote past the request cap")
+	}
+}
+
 func TestMinSeed(t *testing.T) {
 	t.Parallel()
-	raw := [2*minPoolSize - 1]byte{}
-	_, err := NewFortuna(raw[:])
-	if err == nil {
-		t.Error("No error set")
+	raw := [MinSeedSize - 1]byte{}
+	_, err := NewFortuna(raw[:])
+	if err == nil {
+		t.Error("No error set")
+	}
+}
+
+func TestBuildSeed(t *testing.T) {
+	t.Parallel()
+	s := BuildSeed([]byte("host"), []byte("1234"))
+	if len(s) != MinSeedSize {
+		t.Fatalf("Got %d", len(s))
+	}
+	if _, err := NewFortuna(s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s, BuildSeed([]byte("host"), []byte("1234"))) {
+		t.Fatal("Not deterministic")
+	}
+	for i, other := range [][][]byte{
+		{[]byte("host"), []byte("1235")},
+		{[]byte("hos"), []byte("t1234")},
+		{[]byte("host1234")},
+		{[]byte("host"), []byte("1234"), nil},
+	} {
+		if bytes.Equal(s, BuildSeed(other...)) {
+			t.Fatalf("%d: same seed", i)
+		}
+	}
+}
+
+func TestSeedDistribution(t *testing.T) {
+	t.Parallel()
+	data := []struct {
+		seedLen    int
+		minPoolLen int
+		expected   []int
+	}{
+		// The minimum seed; 48 bytes split over 31 pools.
+		{128, 64, []int{48, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		// A multiple of numPools-1.
+		{173, 64, []int{48, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}},
+		{178, 64, []int{48, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}},
+		{192, 64, []int{48, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}},
+		// SHA-512 pools.
+		{256, 128, []int{112, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3}},
+	}
+	for i, line := range data {
+		actual := seedDistribution(line.seedLen, line.minPoolLen)
+		if !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("%d: %v != %v", i, actual, line.expected)
+		}
+		// The 32 bytes of the seed between minPoolLen-16 and minPoolLen+16 are
+		// not used.
+		sum := 0
+		for _, v := range actual {
+			sum += v
+		}
+		if sum != line.seedLen-32 {
+			t.Fatalf("%d: Got %d", i, sum)
+		}
+	}
+}
+
+func TestEntropySourceRandom(t *testing.T) {
+	t.Parallel()
+	// Get the same 4 bytes in 4 tries.
+	if err := CollisionTest(rand.Reader, 4, 4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntropyFortuna(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	// At that point, the accumulator is in a deterministic state; see
+	// TestNewFortunaDeterministic. Entropy must be added via AddRandomEvent().
+	if prng.numReseed != 1 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	notify := prng.ReseedNotify()
+	// Add fake entropy until pool 0 has minPoolSize bytes. In practice you want
+	// to use real entropy.
+	entropy := make([]byte, 32)
+	for i := 0; i <= numPools; i++ {
+		prng.AddRandomEventSync(1, entropy)
+	}
+	// This takes at least reseedInterval (100ms) to complete. Sadly, this slow
+	// down the test by a bit more than 100ms.
+	time.Sleep(reseedInterval)
+	buffer := make([]byte, 1)
+	read(t, prng, buffer, 1)
+	select {
+	case n := <-notify:
+		if n != 2 {
+			t.Fatalf("Got %d", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("No reseed")
+	}
+}
+
+func TestSeededFromEvents(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	if prng.SeededFromEvents() {
+		t.Fatal("Unexpected seeded")
+	}
+	// Pool 1 still contains part of the initial seed.
+	prng.lock.Lock()
+	prng.nextPool = 1
+	prng.lock.Unlock()
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	if !prng.IsSeeded() || prng.SeededFromEvents() {
+		t.Fatal("Expected IsSeeded() only")
+	}
+	// Pool 0 was emptied by the reseed in NewFortuna.
+	prng.lock.Lock()
+	prng.nextPool = 0
+	prng.lock.Unlock()
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	if !prng.SeededFromEvents() {
+		t.Fatal("Expected seeded")
+	}
+}
+
+func TestReseedRate(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	start := time.Now()
+	a.lock.Lock()
+	for i := 0; i < 20; i++ {
+		a.reseed(start.Add(time.Duration(i) * 50 * time.Millisecond))
+	}
+	a.lock.Unlock()
+	end := start.Add(950 * time.Millisecond)
+	if r := a.reseedRate(end, time.Second); r != 20 {
+		t.Fatalf("Got %f", r)
+	}
+	if r := a.reseedRate(end, 500*time.Millisecond); r != 20 {
+		t.Fatalf("Got %f", r)
+	}
+	if r := a.reseedRate(end.Add(time.Second), time.Second); r != 0 {
+		t.Fatalf("Got %f", r)
+	}
+	if r := a.reseedRate(end, 0); r != 0 {
+		t.Fatalf("Got %f", r)
+	}
+	// Only the last reseedHistory reseeds are kept.
+	a.lock.Lock()
+	for i := 0; i < 100; i++ {
+		a.reseed(end.Add(time.Duration(i) * time.Millisecond))
+	}
+	a.lock.Unlock()
+	if r := a.reseedRate(end.Add(100*time.Millisecond), time.Second); r != reseedHistory {
+		t.Fatalf("Got %f", r)
+	}
+	// NewFortuna reseeds once.
+	if r := newFortuna(t).ReseedRate(time.Minute); r != 1./60 {
+		t.Fatalf("Got %f", r)
+	}
+}
+
+func TestNewFortunaDeterministic(t *testing.T) {
+	t.Parallel()
+	// The construction doesn't depend on the clock so two instances from the
+	// same seed have the same generator state and output.
+	a := newFortuna(t)
+	time.Sleep(time.Millisecond)
+	b := newFortuna(t)
+	if !bytes.Equal(a.generator.key, b.generator.key) || !bytes.Equal(a.generator.counter, b.generator.counter) {
+		t.Fatal("Different initial generator state")
+	}
+	for i := range a.pools {
+		if !bytes.Equal(a.pools[i].Sum(nil), b.pools[i].Sum(nil)) {
+			t.Fatalf("Pool %d differs", i)
+		}
+	}
+}
+
+func TestReseedWipesTemp(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	prng.lock.Lock()
+	defer prng.lock.Unlock()
+	// The next reseed drains 12 pools, more than temp can hold.
+	prng.numReseed = 1<<11 - 1
+	prng.reseed(time.Now())
+	if !isZero(prng.temp[:]) {
+		t.Fatal("Pool digests left in temp")
+	}
+}
+
+func TestReseedNotify(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	notify := prng.ReseedNotify()
+	if prng.ReseedNotify() != notify {
+		t.Fatal("Expected the same channel")
+	}
+	prng.ForceReseed()
+	// The channel is full so this value is dropped.
+	prng.ForceReseed()
+	if n := <-notify; n != 2 {
+		t.Fatalf("Got %d", n)
+	}
+	prng.ForceReseed()
+	if n := <-notify; n != 4 {
+		t.Fatalf("Got %d", n)
+	}
+}
+
+// cloneGenerator returns a copy of g's state.
+func cloneGenerator(g *generator) *generator {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	c := newGenerator(nil, nil)
+	copy(c.key, g.key)
+	copy(c.counter, g.counter)
+	c.initialized = g.initialized
+	return c
+}
+
+func TestAddRandomEventSync(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	a.AddRandomEventSync(1, []byte{1, 2, 3})
+	a.AddRandomEventSync(2, make([]byte, 40))
+	if a.nextPool != 2 {
+		t.Fatalf("Got %d", a.nextPool)
+	}
+	// The second event is hashed with SHA-256.
+	if a.pools[0].length != 5 || a.pools[1].length != 34 {
+		t.Fatalf("Got %d, %d", a.pools[0].length, a.pools[1].length)
+	}
+}
+
+func TestEventHash(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{EventHash: sha512.New})
+	data := make([]byte, 40)
+	a.AddRandomEventSync(2, data)
+	if a.pools[0].length != 2+sha512.Size {
+		t.Fatalf("Got %d", a.pools[0].length)
+	}
+	// The pool receives the source, the length and the digest of the data.
+	d := sha512.Sum512(data)
+	expected := sha256.Sum256(append([]byte{2, 40}, d[:]...))
+	if actual := a.pools[0].Sum(nil); !bytes.Equal(expected[:], actual) {
+		t.Fatalf("%x != %x", actual, expected)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	for i := 0; i < 2*numPools; i++ {
+		a.AddRandomEvent(1, []byte{1, 2, 3})
+	}
+	a.Flush()
+	for i := range a.pools {
+		if a.pools[i].length != 10 {
+			t.Fatalf("Pool %d has %d bytes", i, a.pools[i].length)
+		}
+	}
+}
+
+func TestAddTiming(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	a.AddTiming(1)
+	time.Sleep(time.Microsecond)
+	a.AddTiming(1)
+	a.Flush()
+	if a.pools[0].length != 10 || a.pools[1].length != 10 {
+		t.Fatalf("Got %d, %d", a.pools[0].length, a.pools[1].length)
+	}
+	if bytes.Equal(a.pools[0].Sum(nil), a.pools[1].Sum(nil)) {
+		t.Fatal("Both events are the same")
+	}
+}
+
+func TestAddRandomEventEmpty(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	a.AddRandomEvent(1)
+	a.AddRandomEvent(1, nil, []byte{})
+	a.AddRandomEventSync(1, nil)
+	a.Flush()
+	if a.nextPool != 0 || a.pools[0].length != 0 {
+		t.Fatalf("Got %d, %d", a.nextPool, a.pools[0].length)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	// Not parallel to count the goroutines.
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	f, err := NewFortunaWithOptions(raw, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := f.(*accumulator)
+	var lengths [numPools]int
+	for i := range a.pools {
+		lengths[i] = a.pools[i].length
+	}
+	const events = 10 * numPools
+	for i := 0; i < events; i++ {
+		// Alternate small and hashed events.
+		a.AddRandomEvent(1, make([]byte, 8+i/numPools%2*32))
+	}
+	a.Flush()
+	for i := range a.pools {
+		// 5 events of 10 bytes and 5 of 34 bytes.
+		if l := a.pools[i].length - lengths[i]; l != 5*10+5*34 {
+			t.Fatalf("Pool %d: %d", i, l)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The workers may not have exited yet after calling workers.Done().
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("Got %d goroutines, expected %d", n, before)
+	}
+	// Events are dropped once closed.
+	a.AddRandomEvent(1, []byte{1})
+	a.Flush()
+}
+
+func TestMaxEventGoroutines(t *testing.T) {
+	// Not parallel to count the goroutines.
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bound = 10
+	f, err := NewFortunaWithOptions(raw, Options{MaxEventGoroutines: bound})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := f.(*accumulator)
+	before := runtime.NumGoroutine()
+	var lengths [numPools]int
+	for i := range a.pools {
+		lengths[i] = a.pools[i].length
+	}
+	// Block the event goroutines so they accumulate.
+	a.lock.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10*numPools; i++ {
+			a.AddRandomEvent(1, make([]byte, 8))
+		}
+	}()
+	for len(a.eventSem) != bound {
+		time.Sleep(time.Millisecond)
+	}
+	// Give a chance to spawn more goroutines, if the bound was not enforced.
+	time.Sleep(10 * time.Millisecond)
+	// The flooding goroutine is now processing an event inline.
+	if n := runtime.NumGoroutine(); n > before+bound+1 {
+		t.Fatalf("Got %d goroutines, expected at most %d", n, before+bound+1)
+	}
+	a.lock.Unlock()
+	<-done
+	a.Flush()
+	for i := range a.pools {
+		if l := a.pools[i].length - lengths[i]; l != 10*10 {
+			t.Fatalf("Pool %d: %d", i, l)
+		}
+	}
+	if _, err := NewFortunaWithOptions(raw, Options{MaxEventGoroutines: -1}); err == nil {
+		t.Fatal("No error set")
+	}
+}
+
+func TestRandomPools(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFortunaWithOptions(raw, Options{RandomPools: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := f.(*accumulator)
+	var lengths [numPools]int
+	for i := range a.pools {
+		lengths[i] = a.pools[i].length
+	}
+	const perPool = 200
+	for i := 0; i < perPool*numPools; i++ {
+		a.AddRandomEventSync(1, make([]byte, 8))
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	roundRobin := true
+	for i := range a.pools {
+		// Each event is 10 bytes. The standard deviation of the number of
+		// events per pool is ~14, so a 50% tolerance is more than 7 sigmas.
+		n := (a.pools[i].length - lengths[i]) / 10
+		if n < perPool/2 || n > perPool*3/2 {
+			t.Fatalf("Pool %d: %d events", i, n)
+		}
+		if n != perPool {
+			roundRobin = false
+		}
+	}
+	if roundRobin {
+		t.Fatal("Events were distributed round-robin")
+	}
+}
+
+func TestAddRandomEventMulti(t *testing.T) {
+	t.Parallel()
+	long := make([]byte, 40)
+	for i := range long {
+		long[i] = byte(i)
+	}
+	data := []struct {
+		parts  [][]byte
+		concat []byte
+	}{
+		{[][]byte{{1, 2}, {3}, nil, {4, 5, 6}}, []byte{1, 2, 3, 4, 5, 6}},
+		// The 32 bytes limit is checked against the total length.
+		{[][]byte{long[:20], long[20:]}, long},
+	}
+	for i, line := range data {
+		a1 := newAccumulator(Options{})
+		a1.AddRandomEventSync(3, line.parts...)
+		a2 := newAccumulator(Options{})
+		a2.AddRandomEventSync(3, line.concat)
+		if a1.pools[0].length != a2.pools[0].length {
+			t.Fatalf("%d: %d != %d", i, a1.pools[0].length, a2.pools[0].length)
+		}
+		if !bytes.Equal(a1.pools[0].Sum(nil), a2.pools[0].Sum(nil)) {
+			t.Fatalf("%d: different pool content", i)
+		}
+	}
+}
+
+func TestNewFortunaAutoSeed(t *testing.T) {
+	t.Parallel()
+	f, err := NewFortunaAutoSeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(t, f, make([]byte, 16), 16)
+}
+
+func TestNewFortunaAutoSeedWeak(t *testing.T) {
+	// Not parallel since it replaces the process wide systemRand.
+	systemRand = iotest.ErrReader(errors.New("no entropy"))
+	defer func() {
+		systemRand = rand.Reader
+	}()
+	f, err := NewFortunaAutoSeed()
+	if !errors.Is(err, ErrWeakSeed) {
+		t.Fatalf("Got %v", err)
+	}
+	if f == nil {
+		t.Fatal("No instance returned")
+	}
+	read(t, f, make([]byte, 16), 16)
+	if bytes.Equal(weakSeed(), weakSeed()) {
+		t.Fatal("weakSeed is constant")
+	}
+}
+
+func TestReseedFromSystemWeak(t *testing.T) {
+	// Not parallel since it replaces the process wide systemRand.
+	prng := newFortuna(t)
+	systemRand = iotest.ErrReader(errors.New("no entropy"))
+	defer func() {
+		systemRand = rand.Reader
+	}()
+	if err := prng.ReseedFromSystem(); !errors.Is(err, ErrWeakSeed) {
+		t.Fatalf("Got %v", err)
+	}
+	// The instance was reseeded anyway.
+	if prng.numReseed != 2 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	read(t, prng, make([]byte, 16), 16)
+}
+
+func TestReadEvents(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := func(opts Options) int {
+		prng, err := NewFortunaWithOptions(raw, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := prng.(*accumulator)
+		buf := make([]byte, numPools)
+		for i := 1; i <= numPools; i++ {
+			read(t, a, buf[:i], i)
+		}
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		// Skip pools 0 and 1 since a slow test may trigger a reseed.
+		l := 0
+		for i := 2; i < numPools; i++ {
+			l += a.pools[i].length
+		}
+		return l
+	}
+	off := fill(Options{})
+	// Each read adds a 16 bytes event plus its 2 bytes header to a pool.
+	if on, e := fill(Options{ReadEvents: true}), off+(numPools-2)*18; on != e {
+		t.Fatalf("%d != %d", on, e)
+	}
+}
+
+func TestReseedExternal(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := newGenerator(nil, []byte("seed"))
+	c := cloneGenerator(g)
+	expected := make([]byte, 32)
+	read(t, cloneGenerator(g), expected, len(expected))
+	if err := prng.ReseedExternal(g); err != nil {
+		t.Fatal(err)
+	}
+	// The clone that wasn't reseeded is unaffected.
+	buf := make([]byte, 32)
+	read(t, c, buf, len(buf))
+	if !bytes.Equal(buf, expected) {
+		t.Fatal("Not deterministic")
+	}
+	read(t, g, buf, len(buf))
+	if bytes.Equal(buf, expected) {
+		t.Fatal("Not reseeded")
+	}
+	_ = prng.Close()
+	if err := prng.ReseedExternal(g); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestPrimePools(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	// Each pool receives 2 events of 34 bytes.
+	entropy := bytes.NewReader(make([]byte, 2*32*numPools))
+	if err := prng.PrimePools(entropy); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range prng.Stats().PoolLengths {
+		if l < minPoolSize {
+			t.Fatalf("Pool %d: %d", i, l)
+		}
+	}
+	if prng.IsSeeded() {
+		t.Fatal("Unexpected seeded")
+	}
+	prng.lock.Lock()
+	prng.lastReseed = time.Now().Add(-reseedInterval)
+	prng.lock.Unlock()
+	read(t, prng, make([]byte, 1), 1)
+	if prng.numReseed != 2 || !prng.IsSeeded() {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	// The reader is exhausted.
+	if err := prng.PrimePools(entropy); err == nil {
+		t.Fatal("No error set")
+	}
+	_ = prng.Close()
+	if err := prng.PrimePools(entropy); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestSetGenerator(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := make([]byte, 1000)
+			for j := 0; j < 100; j++ {
+				if _, err := prng.Read(d); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	if err := prng.SetGenerator(newGenerator(md5.New(), []byte("other"))); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if l := len(prng.generator.key); l != 16 {
+		t.Fatalf("Got %d", l)
+	}
+
+	// Without entropy in the pools, the output comes from the new generator.
+	prng = newFortuna(t)
+	g := newGenerator(nil, []byte("other"))
+	c := cloneGenerator(g)
+	if err := prng.SetGenerator(g); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, 100)
+	read(t, c, expected, len(expected))
+	actual := make([]byte, len(expected))
+	read(t, prng, actual, len(actual))
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%x != %x", actual, expected)
+	}
+
+	if err := prng.SetGenerator(newGenerator(nil, nil)); err == nil {
+		t.Fatal("No error set")
+	}
+	if err := prng.SetGenerator(prng.Generator()); err == nil {
+		t.Fatal("No error set")
+	}
+	small, err := NewFortunaWithOptions(make([]byte, 128), Options{MaxBytesPerRequest: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := small.SetGenerator(newGenerator(md5.New(), []byte{0})); err == nil {
+		t.Fatal("No error set")
+	}
+	_ = prng.Close()
+	if err := prng.SetGenerator(newGenerator(nil, []byte{0})); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestSeedFromDevice(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	p := filepath.Join(t.TempDir(), "hwrng")
+	data := make([]byte, 32*numPools)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	before := prng.pools[2].length
+	if err := prng.SeedFromDevice(p, len(data)); err != nil {
+		t.Fatal(err)
+	}
+	if prng.numReseed != 2 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	// Pools 0 and 1 were used by the reseed; each of the other ones received a
+	// 32 bytes event, plus its 2 bytes header.
+	if l := prng.pools[2].length; l != before+34 || !prng.hasEvents[2] {
+		t.Fatalf("Got %d", l)
+	}
+	if err := prng.SeedFromDevice(p, len(data)+1); err == nil {
+		t.Fatal("No error set")
+	}
+	if err := prng.SeedFromDevice(filepath.Join(t.TempDir(), "missing"), 1); err == nil {
+		t.Fatal("No error set")
+	}
+	if prng.numReseed != 2 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+}
+
+func TestReseedFromSystem(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	if err := prng.ReseedFromSystem(); err != nil {
+		t.Fatal(err)
+	}
+	if prng.numReseed != 2 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	expected := make([]byte, 32)
+	read(t, g, expected, 32)
+	actual := make([]byte, 32)
+	read(t, &prng.generator, actual, 32)
+	if bytes.Equal(expected, actual) {
+		t.Fatal("ReseedFromSystem didn't change the generator output")
+	}
+}
+
+func TestIsSeeded(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	if prng.IsSeeded() {
+		t.Fatal("Seeded from the initial seed only")
+	}
+	// A reseed without new events doesn't count.
+	prng.ForceReseed()
+	if prng.IsSeeded() {
+		t.Fatal("Seeded without events")
+	}
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	if !prng.IsSeeded() {
+		t.Fatal("Not seeded")
+	}
+}
+
+func TestBlockUntilSeeded(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFortunaWithOptions(raw, Options{BlockUntilSeeded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := f.ReadContext(ctx, make([]byte, 1)); err != context.DeadlineExceeded {
+		t.Fatalf("Got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := f.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Read didn't block: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	f.AddRandomEventSync(1, make([]byte, 32))
+	f.ForceReseed()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequireRealEntropy(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFortunaWithOptions(raw, Options{RequireRealEntropy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 1)
+	if n, err := f.Read(data); n != 0 || err != ErrInsufficientEntropy {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if _, err := f.ReadVectored(data); err != ErrInsufficientEntropy {
+		t.Fatalf("Got %v", err)
+	}
+	if _, err := f.ReadFresh(data); err != ErrInsufficientEntropy {
+		t.Fatalf("Got %v", err)
+	}
+	// A reseed without new events doesn't count.
+	f.ForceReseed()
+	if _, err := f.Read(data); err != ErrInsufficientEntropy {
+		t.Fatalf("Got %v", err)
+	}
+	f.AddRandomEventSync(1, make([]byte, 32))
+	f.ForceReseed()
+	read(t, f, data, 1)
+}
+
+func TestNextReseed(t *testing.T) {
+	t.Parallel()
+	start := time.Now()
+	prng := newFortuna(t)
+	last := prng.LastReseed()
+	if last.Before(start) || last.After(time.Now()) {
+		t.Fatalf("Got %s", last)
+	}
+	if n := prng.NextReseed(); !n.Equal(last.Add(reseedInterval)) {
+		t.Fatalf("%s != %s", n, last.Add(reseedInterval))
+	}
+	prng.ForceReseed()
+	if l := prng.LastReseed(); l.Before(last) {
+		t.Fatalf("Got %s", l)
+	}
+}
+
+func TestReseedDrainAll(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	ch := prng.ReseedNotify()
+	prng.ReseedDrainAll()
+	for i := range prng.pools {
+		if prng.pools[i].length != 0 {
+			t.Fatalf("Pool %d has %d bytes", i, prng.pools[i].length)
+		}
+	}
+	// The bookkeeping is the same as a scheduled reseed.
+	select {
+	case n := <-ch:
+		if n != 2 {
+			t.Fatalf("Got %d", n)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("No notification")
+	}
+	if prng.numReseed != 2 {
+		t.Fatalf("Got %d", prng.numReseed)
+	}
+	if r := prng.ReseedRate(time.Hour); r != 2./3600 {
+		t.Fatalf("Got %f", r)
+	}
+	actual := make([]byte, 32)
+	read(t, prng, actual, 32)
+	expected := make([]byte, 32)
+	read(t, g, expected, 32)
+	if bytes.Equal(expected, actual) {
+		t.Fatal("The generator wasn't reseeded")
+	}
+}
+
+func TestPoolDrainCounts(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	// The constructor did the first reseed.
+	for i := 1; i < 64; i++ {
+		prng.ForceReseed()
+	}
+	counts := prng.PoolDrainCounts()
+	for i, c := range counts {
+		expected := 0
+		if i < 7 {
+			expected = 64 >> uint(i)
+		}
+		if c != expected {
+			t.Fatalf("Pool %d: %d != %d; %v", i, c, expected, counts)
+		}
+	}
+}
+
+func TestResetPools(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	for i := 0; i < 3; i++ {
+		prng.AddRandomEventSync(1, make([]byte, 32))
+	}
+	prng.ForceReseed()
+	key := append([]byte{}, prng.generator.key...)
+	prng.ResetPools()
+	if prng.numReseed != 0 || prng.nextPool != 0 {
+		t.Fatalf("Got %d, %d", prng.numReseed, prng.nextPool)
+	}
+	for i := range prng.pools {
+		if prng.pools[i].length != 0 {
+			t.Fatalf("Pool %d has %d bytes", i, prng.pools[i].length)
+		}
+	}
+	if !bytes.Equal(key, prng.generator.key) {
+		t.Fatal("The key changed")
+	}
+	// The next reseed only uses pool 0, like the first one.
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	if prng.numReseed != 1 || prng.pools[0].length != 0 || prng.pools[1].length != 34 {
+		t.Fatalf("Got %d, %d, %d", prng.numReseed, prng.pools[0].length, prng.pools[1].length)
+	}
+}
+
+func TestReseedFromScratch(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prng := newFortuna(t)
+	prng.generator.continuousTest = true
+	data := make([]byte, 64)
+	read(t, prng, data, len(data))
+	// Fill the Int63 cache and mark the generator as stuck.
+	if _, err := prng.Int63(); err != nil {
+		t.Fatal(err)
+	}
+	prng.generator.stuck = true
+	for i := 0; i < 2*numPools; i++ {
+		prng.AddRandomEventSync(1, make([]byte, 32))
+	}
+	prng.ForceReseed()
+	if !prng.IsSeeded() {
+		t.Fatal("Not seeded")
+	}
+	// Pending events are flushed before the reset.
+	prng.AddRandomEvent(1, make([]byte, 32))
+	if err := prng.ReseedFromScratch(raw); err != nil {
+		t.Fatal(err)
+	}
+	if prng.IsSeeded() || prng.numReseed != 1 {
+		t.Fatalf("Got %t, %d", prng.IsSeeded(), prng.numReseed)
+	}
+	fresh := newFortuna(t)
+	fresh.generator.continuousTest = true
+	if a, e := prng.ReseedRate(time.Hour), fresh.ReseedRate(time.Hour); a != e {
+		t.Fatalf("%f != %f", a, e)
+	}
+	read(t, prng, data, len(data))
+	expected := make([]byte, len(data))
+	read(t, fresh, expected, len(expected))
+	if !bytes.Equal(expected, data) {
+		t.Fatalf("%v != %v", data, expected)
+	}
+	a, err := prng.Int63()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := fresh.Int63(); err != nil || a != e {
+		t.Fatalf("%d != %d, %v", a, e, err)
+	}
+	sa, se := prng.Stats(), fresh.Stats()
+	sa.LastReseed, se.LastReseed = time.Time{}, time.Time{}
+	if sa != se {
+		t.Fatalf("%+v != %+v", sa, se)
+	}
+	if err := prng.ReseedFromScratch(raw[:MinSeedSize-1]); err == nil {
+		t.Fatal("No error set")
+	}
+}
+
+func TestNewFortunaLazy(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	var calls int32
+	f, err := NewFortunaLazy(func() ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return append([]byte{}, raw...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 32)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Read(data[:1]); err != ErrNotSeeded {
+				t.Errorf("Got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(release)
+	for {
+		_, err := f.Read(data)
+		if err == nil {
+			break
+		}
+		if err != ErrNotSeeded {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatalf("Got %d", c)
+	}
+	// Same output as NewFortuna.
+	expected := make([]byte, 32)
+	read(t, newFortuna(t), expected, len(expected))
+	if !bytes.Equal(expected, data) {
+		t.Fatalf("%v != %v", data, expected)
+	}
+}
+
+func TestNewFortunaLazyEarlyEvent(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	f, err := NewFortunaLazy(func() ([]byte, error) {
+		<-release
+		return append([]byte{}, raw...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The event moves nextPool before the seed is distributed.
+	f.AddRandomEventSync(1, []byte{1})
+	close(release)
+	for {
+		_, err := f.Read(make([]byte, 1))
+		if err == nil {
+			break
+		}
+		if err != ErrNotSeeded {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a := f.(*accumulator)
+	fresh := newFortuna(t)
+	// The seed went to the same pools as with NewFortuna and the initial
+	// reseed drained pool 0, which received it.
+	if l, e := a.Stats().PoolLengths, fresh.Stats().PoolLengths; l != e {
+		t.Fatalf("%v != %v", l, e)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.nextPool != 1 {
+		t.Fatalf("Got %d", a.nextPool)
+	}
+	for i := 1; i < numPools; i++ {
+		if !bytes.Equal(a.pools[i].Sum(nil), fresh.pools[i].Sum(nil)) {
+			t.Fatalf("Pool %d differs", i)
+		}
+	}
+}
+
+func TestNewFortunaLazyFail(t *testing.T) {
+	t.Parallel()
+	f, err := NewFortunaLazy(func() ([]byte, error) {
+		return nil, errors.New("oops")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AddRandomEvent also starts the fetch.
+	f.AddRandomEvent(1, []byte{1})
+	for {
+		_, err := f.Read(make([]byte, 1))
+		if err == ErrNotSeeded {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err == nil || err.Error() != "failed to get the seed: oops" {
+			t.Fatalf("Got %v", err)
+		}
+		break
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	if err := prng.AddRandomEventErr(1, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := prng.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := prng.AddRandomEventErr(1, []byte{1}); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	// The compatible forms silently drop the events.
+	prng.AddRandomEvent(1, []byte{1})
+	prng.AddRandomEventSync(1, []byte{1})
+	if _, err := prng.Read(make([]byte, 1)); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	if err := prng.ReseedFromSystem(); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	prng.lock.Lock()
+	defer prng.lock.Unlock()
+	for i := range prng.pools {
+		if prng.pools[i].length != 0 {
+			t.Fatalf("Pool %d has %d bytes", i, prng.pools[i].length)
+		}
+	}
+	if !isZero(prng.generator.key) {
+		t.Fatal("Key not wiped")
+	}
+}
+
+func TestPoolHash(t *testing.T) {
+	t.Parallel()
+	opts := Options{PoolHash: sha512.New}
+	if _, err := NewFortunaWithOptions(make([]byte, 2*minPoolSize), opts); err == nil {
+		t.Fatal("SHA-512 has a larger block size, the seed is too short")
+	}
+	f, err := NewFortunaWithOptions(make([]byte, 2*sha512.BlockSize), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := f.(*accumulator)
+	if a.minPoolLen != sha512.BlockSize {
+		t.Fatalf("Got %d", a.minPoolLen)
+	}
+	fill := func(l int) {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		_, _ = a.pools[0].Write(make([]byte, l))
+		// Skip reseedInterval.
+		a.lastReseed = time.Time{}
+	}
+	buffer := make([]byte, 1)
+	fill(minPoolSize)
+	read(t, a, buffer, 1)
+	if a.numReseed != 1 {
+		t.Fatalf("Reseeded with %d bytes in pool 0", a.pools[0].length)
+	}
+	fill(sha512.BlockSize - minPoolSize)
+	read(t, a, buffer, 1)
+	if a.numReseed != 2 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+	// The second reseed drains pool 0 and 1 but not 2.
+	if a.pools[0].length != 0 || a.pools[1].length != 0 || a.pools[2].length == 0 {
+		t.Fatalf("Got %d, %d, %d", a.pools[0].length, a.pools[1].length, a.pools[2].length)
 	}
 }
 
-// Fetches a numBytes bytes block maxTries times.
-//
-// It should never be the same return value for the same seed. Define X =
-// 2<<(8*numBytes). The theorical probability of this happening is
-// P( X! / (X^maxTries * (X-maxTries)!) ).
-func bruteForce(t *testing.T, name string, maxTries, numBytes int, read func([]byte)) {
-	blocks := map[string]bool{}
-	for i := 0; i < maxTries; i++ {
-		data := make([]byte, numBytes)
-		read(data)
-		str := string(data)
-		if blocks[str] {
-			t.Fatalf("The PRNG %s returned the same %d bytes blocks 2 times after %d requests (out of %d): %v", name, numBytes, i, maxTries, data)
+func TestKeySize(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeySize := func(k int) *accumulator {
+		prng, err := NewFortunaWithOptions(raw, Options{KeySize: k})
+		if err != nil {
+			t.Fatal(err)
 		}
-		blocks[str] = true
+		return prng.(*accumulator)
+	}
+	a := newKeySize(16)
+	if l := len(a.generator.key); l != 16 {
+		t.Fatalf("Got %d", l)
+	}
+	// The output is AES-128 of the counter.
+	c, err := aes.NewCipher(a.generator.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, aes.BlockSize)
+	c.Encrypt(expected, a.generator.counter)
+	actual := make([]byte, 64)
+	read(t, a, actual, len(actual))
+	if !bytes.Equal(expected, actual[:aes.BlockSize]) {
+		t.Fatalf("%v != %v", actual[:aes.BlockSize], expected)
+	}
+	// The new key is 16 bytes too.
+	if l := len(a.generator.key); l != 16 {
+		t.Fatalf("Got %d", l)
+	}
+	// The seed without any entropy added results in a deterministic output.
+	other := make([]byte, 64)
+	read(t, newKeySize(16), other, len(other))
+	if !bytes.Equal(actual, other) {
+		t.Fatalf("%v != %v", other, actual)
+	}
+	read(t, newKeySize(32), other, len(other))
+	if bytes.Equal(actual, other) {
+		t.Fatal("AES-128 and AES-256 returned the same output")
+	}
+	if _, err := NewFortunaWithOptions(raw, Options{KeySize: 24}); err == nil {
+		t.Fatal("No error set")
 	}
 }
 
-func TestEntropySourceRandom(t *testing.T) {
+func TestGeneratorHash(t *testing.T) {
 	t.Parallel()
-	// Get the same 4 bytes in 4 tries.
-	bruteForce(t, "crypto/rand", 4, 4, func(data []byte) {
-		n, err := rand.Read(data)
-		if n != len(data) || err != nil {
-			t.Fatal("Internal failure reading randomness")
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newGeneratorHash := func(h func() hash.Hash) *accumulator {
+		prng, err := NewFortunaWithOptions(raw, Options{GeneratorHash: h})
+		if err != nil {
+			t.Fatal(err)
 		}
-	})
+		return prng.(*accumulator)
+	}
+	a := newGeneratorHash(sha512.New)
+	if l := len(a.generator.key); l != 32 {
+		t.Fatalf("Got %d", l)
+	}
+	if a.generator.maxBytesPerRequest != 1<<20 {
+		t.Fatalf("Got %d", a.generator.maxBytesPerRequest)
+	}
+	actual := make([]byte, 64)
+	read(t, a, actual, len(actual))
+	other := make([]byte, 64)
+	read(t, newGeneratorHash(sha512.New), other, len(other))
+	if !bytes.Equal(actual, other) {
+		t.Fatalf("%v != %v", other, actual)
+	}
+	read(t, newGeneratorHash(nil), other, len(other))
+	if bytes.Equal(actual, other) {
+		t.Fatal("SHA-512 and SHA-256 returned the same output")
+	}
+	if _, err := NewFortunaWithOptions(raw, Options{GeneratorHash: md5.New}); err == nil {
+		t.Fatal("No error set")
+	}
+	f, err := NewFortunaWithOptions(raw, Options{GeneratorHash: md5.New, KeySize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := f.SecurityBits(); b != 64 {
+		t.Fatalf("Got %d", b)
+	}
+	if b := a.SecurityBits(); b != 256 {
+		t.Fatalf("Got %d", b)
+	}
+	bad := func() hash.Hash { return shortHash{sha256.New()} }
+	for i, opts := range []Options{{GeneratorHash: bad}, {PoolHash: bad}, {EventHash: bad}} {
+		if _, err := NewFortunaWithOptions(raw, opts); err == nil {
+			t.Fatalf("%d: No error set", i)
+		}
+	}
 }
 
-func TestEntropyFortuna(t *testing.T) {
+func TestReadFresh(t *testing.T) {
 	t.Parallel()
 	prng := newFortuna(t)
-	// At that point, the accumulator is in a somewhat deterministic state.
-	// Entropy was added via time.Now() but creating two instances twice
-	// sufficiently fast will result in objects with the exact same internal
-	// state. Entropy must be added via AddRandomEvent().
-	if prng.numReseed != 1 {
-		t.Fatalf("Got %d", prng.numReseed)
+	g := cloneGenerator(&prng.generator)
+	for i := 0; i < 2*numPools; i++ {
+		prng.AddRandomEventSync(1, make([]byte, 32))
 	}
-	// This takes at least reseedInterval (100ms) to complete. Sadly, this slow
-	// down the test by a bit more than 100ms.
-	entropy := make([]byte, 32)
+	actual := make([]byte, 32)
+	if n, err := prng.ReadFresh(actual); n != len(actual) || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if !prng.LastReadWasFresh() {
+		t.Fatal("Expected a reseed")
+	}
+	expected := make([]byte, 32)
+	read(t, g, expected, len(expected))
+	if bytes.Equal(expected, actual) {
+		t.Fatal("The generator wasn't reseeded")
+	}
+	// Pool 0 is now empty.
+	if n, err := prng.ReadFresh(actual); n != len(actual) || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if prng.LastReadWasFresh() {
+		t.Fatal("Unexpected reseed")
+	}
+}
+
+func TestStreamToWithDigest(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	buf := bytes.Buffer{}
+	const size = 100000
+	n, digest, err := prng.StreamToWithDigest(&buf, size, sha256.New())
+	if n != size || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if expected := sha256.Sum256(buf.Bytes()); !bytes.Equal(expected[:], digest) {
+		t.Fatalf("%x != %x", digest, expected)
+	}
+	// The data is read in 32kb requests.
+	expected := make([]byte, size)
+	for i := 0; i < size; i += 32 * 1024 {
+		read(t, g, expected[i:min(i+32*1024, size)], min(32*1024, size-i))
+	}
+	if !bytes.Equal(expected, buf.Bytes()) {
+		t.Fatal("Unexpected data")
+	}
+}
+
+// cancelWriter cancels a context once it received n bytes.
+type cancelWriter struct {
+	bytes.Buffer
+	n      int
+	cancel func()
+}
+
+func (c *cancelWriter) Write(p []byte) (int, error) {
+	n, err := c.Buffer.Write(p)
+	if c.Len() >= c.n {
+		c.cancel()
+	}
+	return n, err
+}
+
+func TestStreamToContext(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	buf := bytes.Buffer{}
+	const size = 100000
+	if n, err := prng.StreamToContext(context.Background(), &buf, size); n != size || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	expected := make([]byte, size)
+	for i := 0; i < size; i += 32 * 1024 {
+		read(t, g, expected[i:min(i+32*1024, size)], min(32*1024, size-i))
+	}
+	if !bytes.Equal(expected, buf.Bytes()) {
+		t.Fatal("Unexpected data")
+	}
+
+	// Cancel after the second chunk.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelWriter{n: 64 * 1024, cancel: cancel}
+	if n, err := prng.StreamToContext(ctx, w, size); n != 64*1024 || err != context.Canceled {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if w.Len() != 64*1024 {
+		t.Fatalf("Got %d", w.Len())
+	}
+}
+
+func TestReadVectored(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	a, b := make([]byte, 5), make([]byte, 20)
+	if n, err := prng.ReadVectored(a, b); n != 25 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	expected := make([]byte, 25)
+	read(t, g, expected, 25)
+	if actual := append(a, b...); !bytes.Equal(expected, actual) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	h := prng.Generator()
+	// Both handles consume the same stream.
+	actual := make([]byte, 30)
+	read(t, h, actual[:10], 10)
+	read(t, prng, actual[10:], 20)
+	expected := make([]byte, 30)
+	read(t, g, expected[:10], 10)
+	read(t, g, expected[10:], 20)
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+	// Write reseeds the internal generator directly.
+	if n, err := h.Write([]byte("seed")); n != 4 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	_, _ = g.Write([]byte("seed"))
+	read(t, prng, actual, len(actual))
+	read(t, g, expected, len(expected))
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+	_ = prng.Close()
+	if _, err := h.Write([]byte("seed")); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	if _, err := h.Read(actual); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	prng, err := NewFortunaWithOptions(raw, Options{Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := prng.(*accumulator)
+	if s := buf.String(); !strings.Contains(s, "msg=\"fortuna: reseed\" count=1 pools=1\n") {
+		t.Fatalf("Got %q", s)
+	}
+	buf.Reset()
+	a.lock.Lock()
+	a.lastReseed = time.Now().Add(time.Hour)
+	a.lock.Unlock()
+	read(t, a, make([]byte, 1), 1)
+	if s := buf.String(); !strings.Contains(s, "level=WARN msg=\"fortuna: clock went backward\"") {
+		t.Fatalf("Got %q", s)
+	}
+}
+
+func TestWarnEarlyRead(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	prng, err := NewFortunaWithOptions(raw, Options{Logger: l, WarnEarlyRead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(t, prng, make([]byte, 1), 1)
+	const msg = "level=WARN msg=\"fortuna: read before being seeded from events\""
+	if s := buf.String(); strings.Count(s, msg) != 1 {
+		t.Fatalf("Got %q", s)
+	}
+	read(t, prng, make([]byte, 1), 1)
+	if s := buf.String(); strings.Count(s, msg) != 1 {
+		t.Fatalf("Got %q", s)
+	}
+
+	// No warning once seeded.
+	buf.Reset()
+	prng, err = NewFortunaWithOptions(raw, Options{Logger: l, WarnEarlyRead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	read(t, prng, make([]byte, 1), 1)
+	if s := buf.String(); s != "" {
+		t.Fatalf("Got %q", s)
+	}
+}
+
+// Hammers the instance from multiple goroutines. It is mostly useful with
+// -race.
+func TestConcurrency(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prng, err := NewFortunaWithOptions(raw, Options{EstimateEntropy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(300 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func(source byte) {
+			defer wg.Done()
+			data := make([]byte, 32)
+			for time.Now().Before(deadline) {
+				prng.AddRandomEvent(source, data)
+				prng.AddRandomEventSync(source, data[:8])
+			}
+		}(byte(i))
+		go func() {
+			defer wg.Done()
+			data := make([]byte, 100)
+			for time.Now().Before(deadline) {
+				if _, err := prng.Read(data); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := prng.ReadVectored(data[:10], data[10:]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func(source byte) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				prng.IsSeeded()
+				prng.NextReseed()
+				prng.EntropyEstimate(source)
+				prng.ForceReseed()
+				time.Sleep(time.Millisecond)
+			}
+		}(byte(i))
+	}
+	wg.Wait()
+	if !prng.IsSeeded() {
+		t.Fatal("Expected to be seeded")
+	}
+	if err := prng.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	actual := make([]byte, 2<<20+10)
+	if err := prng.ReadInto(actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, len(actual))
+	for i := 0; i < len(expected); {
+		n, err := g.Read(expected[i:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		i += n
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatal("ReadInto differs from a Read loop")
+	}
+	_ = prng.Close()
+	if err := prng.ReadInto(actual); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestFillLarge(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	g := cloneGenerator(&prng.generator)
+	actual := make([]byte, 3<<20+10)
+	if err := prng.FillLarge(actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := make([]byte, len(actual))
+	for i := 0; i < len(expected); {
+		n, err := g.Read(expected[i:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		i += n
+	}
+	if !bytes.Equal(expected, actual) {
+		t.Fatal("FillLarge differs from a Read loop")
+	}
+}
+
+func TestClockJumpReseed(t *testing.T) {
+	t.Parallel()
+	raw, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prng, err := NewFortunaWithOptions(raw, Options{ClockJumpReseed: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := prng.(*accumulator)
 	buffer := make([]byte, 1)
-	for {
-		// Add fake entropy. In practice you want to use real entropy.
-		prng.AddRandomEvent(1, entropy)
-		read(t, prng, buffer, 1)
-		if prng.numReseed == 2 {
-			// This can only happen after reseedInterval has passed and at least
-			// minPoolSize was written to the first pool.
-			break
+	read(t, a, buffer, 1)
+	if a.numReseed != 1 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+	// Simulate a VM suspended for an hour. Pool 0 has no entropy so the reseed
+	// can only be caused by the jump.
+	a.lock.Lock()
+	a.lastReseed = time.Now().Round(0).Add(-time.Hour)
+	a.lock.Unlock()
+	read(t, a, buffer, 1)
+	if a.numReseed != 2 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+	read(t, a, buffer, 1)
+	if a.numReseed != 2 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+}
+
+func TestMaxKeyAge(t *testing.T) {
+	t.Parallel()
+	prng, err := NewFortunaWithOptions(make([]byte, 128), Options{MaxKeyAge: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := prng.(*accumulator)
+	buffer := make([]byte, 1)
+	read(t, a, buffer, 1)
+	if a.numReseed != 1 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+	// Age the key. Pool 0 has no entropy so the reseed can only be caused by
+	// the age.
+	a.lock.Lock()
+	a.lastReseed = time.Now().Add(-2 * time.Minute)
+	a.lock.Unlock()
+	read(t, a, buffer, 1)
+	if a.numReseed != 2 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+	read(t, a, buffer, 1)
+	if a.numReseed != 2 {
+		t.Fatalf("Got %d", a.numReseed)
+	}
+}
+
+func TestAuditHash(t *testing.T) {
+	t.Parallel()
+	// Without entropy in the pools, the accumulator never reseeds so its output
+	// is deterministic.
+	a := newAccumulator(Options{})
+	_, _ = a.generator.Write([]byte{0})
+	if a.AuditDigest() != nil {
+		t.Fatal("Unexpected digest")
+	}
+	a.SetAuditHash(sha256.New())
+	g := newGenerator(nil, []byte{0})
+	h := sha256.New()
+	data := make([]byte, 70)
+	for _, l := range []int{70, 10, 1} {
+		read(t, a, data[:l], l)
+		actual := a.AuditDigest()
+		read(t, g, data[:l], l)
+		_, _ = h.Write(data[:l])
+		if expected := h.Sum(nil); !bytes.Equal(expected, actual) {
+			t.Fatalf("%x != %x", actual, expected)
 		}
 	}
+	a.SetAuditHash(nil)
+	read(t, a, data, len(data))
+	if a.AuditDigest() != nil {
+		t.Fatal("Unexpected digest")
+	}
 }
 
 // Benches large chunks throughput. Calculates the cost per byte.
@@ -125,6 +1723,75 @@ func BenchmarkFortunaLarge(b *testing.B) {
 	}
 }
 
+// Fills 256Mb with FillLarge.
+func BenchmarkFortunaFillLarge(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 256<<20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := f.FillLarge(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Fills 256Mb with a loop of Read, to compare with BenchmarkFortunaFillLarge.
+func BenchmarkFortunaReadLoopLarge(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 256<<20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadFull(f, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Reads 32 bytes at a time with Read, to compare with
+// BenchmarkFortunaReadInto32Bytes.
+func BenchmarkFortunaRead32Bytes(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 32)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Read(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Reads 32 bytes at a time with ReadInto.
+func BenchmarkFortunaReadInto32Bytes(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := make([]byte, 32)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := f.ReadInto(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // Reads 1 byte at a time to bench overhead. Calculates the cost per byte.
 func BenchmarkFortuna1Byte(b *testing.B) {
 	f, err := NewFortuna(make([]byte, 128))
@@ -171,6 +1838,32 @@ func BenchmarkFortuna16Bytes(b *testing.B) {
 	}
 }
 
+// Reseeds the accumulator from 1, 5, 17 and all the pools. Calculates the
+// cost per reseed, to compare with BenchmarkGeneratorReseed.
+func BenchmarkAccumulatorReseed(b *testing.B) {
+	for _, pools := range []int{1, 5, 17, numPools} {
+		b.Run(fmt.Sprintf("%dPools", pools), func(b *testing.B) {
+			a := newAccumulator(Options{})
+			_, _ = a.generator.Write([]byte{0})
+			event := a.frameEvent(1, [][]byte{make([]byte, 32)})
+			a.lock.Lock()
+			defer a.lock.Unlock()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < pools; j++ {
+					_, _ = a.pools[j].Write(event)
+				}
+				// The next reseed is number 2^(pools-1), which includes pools P_0 to
+				// P_pools-1.
+				a.numReseed = 1<<(pools-1) - 1
+				b.StartTimer()
+				a.reseed(time.Time{})
+			}
+		})
+	}
+}
+
 // Adds random event. Calculates the cost per adding random event.
 func BenchmarkFortunaAddRandomEvent(b *testing.B) {
 	f, err := NewFortuna(make([]byte, 128))
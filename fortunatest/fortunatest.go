@@ -0,0 +1,64 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package fortunatest contains helpers to test the configurations of package
+// fortuna.
+package fortunatest
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"testing"
+
+	"github.com/maruel/fortuna"
+)
+
+// TestSeed returns the 128 bytes 0x00 to 0x7F, the seed used by the tests of
+// package fortuna, so other tests can reproduce its known outputs.
+func TestSeed() []byte {
+	s := make([]byte, 128)
+	for i := range s {
+		s[i] = byte(i)
+	}
+	return s
+}
+
+// AssertDeterministic fails t if two generators using the hash returned by h
+// and seeded with seed don't return the same output for each of the reads
+// lengths. h is optional and defaults to SHA-256. seed must not be empty.
+//
+// Like TestGeneratorDeterminism, one generator is seeded at construction and
+// the other one is seeded later with Write(), and the output must overwrite
+// the buffers passed to Read().
+func AssertDeterministic(t testing.TB, h func() hash.Hash, seed []byte, reads []int) {
+	t.Helper()
+	if len(seed) == 0 {
+		t.Fatal("seed must not be empty")
+	}
+	newHash := func() hash.Hash {
+		if h == nil {
+			return nil
+		}
+		return h()
+	}
+	g1 := fortuna.NewGenerator(newHash(), seed)
+	g2 := fortuna.NewGenerator(newHash(), nil)
+	if _, err := g2.Write(seed); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range reads {
+		d1 := make([]byte, l)
+		if _, err := io.ReadFull(g1, d1); err != nil {
+			t.Fatal(err)
+		}
+		d2 := bytes.Repeat([]byte{0xFF}, l)
+		if _, err := io.ReadFull(g2, d2); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d1, d2) {
+			t.Fatalf("Read %d of %d bytes differs between early and late seeding:\n%x\n%x", i, l, d1, d2)
+		}
+	}
+}
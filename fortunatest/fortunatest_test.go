@@ -0,0 +1,91 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortunatest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"runtime"
+	"testing"
+)
+
+func TestTestSeed(t *testing.T) {
+	t.Parallel()
+	// Same as the seed constant in package fortuna's tests.
+	const seed = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4vMDEyMzQ1Njc4OTo7PD0+P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZWltcXV5fYGFiY2RlZmdoaWprbG1ub3BxcnN0dXZ3eHl6e3x9fn8="
+	s := TestSeed()
+	if len(s) != 128 {
+		t.Fatalf("Got %d", len(s))
+	}
+	for i, b := range s {
+		if b != byte(i) {
+			t.Fatalf("%d: Got %d", i, b)
+		}
+	}
+	e, err := base64.StdEncoding.DecodeString(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s, e) {
+		t.Fatalf("%x != %x", s, e)
+	}
+	AssertDeterministic(t, nil, s, []int{1, 16, 100})
+}
+
+func TestAssertDeterministic(t *testing.T) {
+	t.Parallel()
+	reads := []int{1, 16, 0, 1000, 1 << 20}
+	AssertDeterministic(t, nil, []byte("seed"), reads)
+	AssertDeterministic(t, sha256.New, []byte("seed"), reads)
+	AssertDeterministic(t, md5.New, []byte{0}, reads)
+}
+
+func TestAssertDeterministicFail(t *testing.T) {
+	t.Parallel()
+	f := &fakeTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AssertDeterministic(f, func() hash.Hash { return randomHash{sha256.New()} }, []byte("seed"), []int{16})
+	}()
+	<-done
+	if f.msg == "" {
+		t.Fatal("AssertDeterministic didn't fail")
+	}
+}
+
+// randomHash is a nondeterministic hash.
+type randomHash struct {
+	hash.Hash
+}
+
+func (r randomHash) Sum(b []byte) []byte {
+	d := make([]byte, r.Size())
+	_, _ = rand.Read(d)
+	return append(b, d...)
+}
+
+// fakeTB records the failure instead of failing the test.
+type fakeTB struct {
+	testing.TB
+	msg string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Fatal(args ...interface{}) {
+	f.msg = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.msg = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
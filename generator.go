@@ -8,21 +8,52 @@
 package fortuna
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"sync"
+	"unsafe"
 )
 
+// ErrStuckGenerator is returned when the continuous test detects two
+// consecutive identical blocks of output, which denotes a catastrophic failure
+// of the generator. See Options.ContinuousTest.
+var ErrStuckGenerator = errors.New("generator returned the same block twice")
+
 type generator struct {
 	// Internal state
 	lock               sync.Mutex
 	key                []byte  // The current key is used to seed the next one.
 	counter            counter // The counter is always 128 bytes since it is used as the IV for CTR.
 	maxBytesPerRequest int
+	generated          uint64 // Number of bytes returned by Read and readVectored
+
+	// forwardSecure is true by default. When false, the key is not replaced
+	// after each request so the output is a plain AES-CTR stream (with a
+	// little endian counter).
+	//
+	// INSECURE: a compromise of the state then reveals all the previous output.
+	// This is only meant for testing, benchmarking the raw cipher path and
+	// interoperability tests.
+	forwardSecure bool
+	// mixCounter feeds the counter in the hash when reseeding, so the new key
+	// depends on the whole previous state. It is set by Options.MixCounter.
+	mixCounter bool
+	// bigEndian generates the blocks with cipher.NewCTR and treats the counter
+	// as a big endian integer. It is set by Options.CTRCompat.
+	bigEndian bool
+	// continuousTest compares each block of output with the previous one and
+	// sets stuck when they are identical. It is set by Options.ContinuousTest.
+	continuousTest bool
+	stuck          bool
+	lastBlock      []byte
+	// newCipher is aes.NewCipher, except in tests.
+	newCipher func(key []byte) (cipher.Block, error)
 
 	// Cache.
 	initialized bool      // false if bytes.Equal(counter, make(counter, len(counter)).
@@ -47,30 +78,74 @@ type generator struct {
 // output. The resulting PRNG is guaranteed to not leak its internal state
 // after each Read() call.
 //
-// The resulting object is thread-safe.
+// The resulting object is thread-safe. It panics if h's Sum doesn't return
+// Size bytes; use NewGeneratorChecked to get an error instead.
 func NewGenerator(h hash.Hash, seed []byte) io.ReadWriter {
-	g := newGenerator(h, seed)
-	return &g
+	g, err := NewGeneratorChecked(h, seed)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// NewGeneratorChecked is the same as NewGenerator except that it returns an
+// error if h's Sum doesn't return Size bytes, which would leave part of the
+// key unchanged on each reseed.
+func NewGeneratorChecked(h hash.Hash, seed []byte) (io.ReadWriter, error) {
+	if h != nil {
+		if err := checkHash(h); err != nil {
+			return nil, err
+		}
+	}
+	return newGenerator(h, seed), nil
+}
+
+// checkHash returns an error if h's digest is not Size bytes, which would
+// leave part of the key unchanged on each reseed.
+func checkHash(h hash.Hash) error {
+	if l := len(h.Sum(nil)); l != h.Size() {
+		return fmt.Errorf("hash returned a %d bytes digest but its Size is %d", l, h.Size())
+	}
+	return nil
+}
+
+func newGenerator(h hash.Hash, seed []byte) *generator {
+	g := &generator{}
+	g.init(h, seed)
+	return g
 }
 
-// newGenerator is used internally for the Accumulator to save a pointer
-// dereference.
-func newGenerator(h hash.Hash, seed []byte) generator {
+// init initializes the generator in place. It is used internally for the
+// Accumulator to save a pointer dereference.
+func (g *generator) init(h hash.Hash, seed []byte) {
 	if h == nil {
 		h = sha256.New()
 	}
 	b := h.Size()
-	g := generator{
-		key:                make([]byte, b),
-		counter:            make([]byte, 16),
-		maxBytesPerRequest: (1 << 15) * b,
-		temp:               make([]byte, b),
-		h:                  h,
-	}
+	g.key = make([]byte, b)
+	g.counter = make([]byte, 16)
+	g.maxBytesPerRequest = (1 << 15) * b
+	g.temp = make([]byte, b)
+	g.h = h
+	g.forwardSecure = true
+	g.newCipher = aes.NewCipher
 	if len(seed) != 0 {
 		_, _ = g.Write(seed)
 	}
-	return g
+}
+
+// setKeySize selects AES-128 with 16 or AES-256 with 32, independently of the
+// hash size. It must be called before the generator is seeded.
+func (g *generator) setKeySize(n int) {
+	g.key = make([]byte, n)
+}
+
+// SecurityBits returns the security level in bits, which is half the hash
+// output size per the n/2 security claim of SHAd in p. 86.
+func (g *generator) SecurityBits() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.h.Size() * 8 / 2
 }
 
 // Write updates the PRNG state with an arbitrary input string.
@@ -79,17 +154,51 @@ func (g *generator) Write(data []byte) (int, error) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
-	g.key = DoubleHash(g.h, g.key, data)
-	g.counter.incr()
+	var k []byte
+	if g.mixCounter {
+		k = DoubleHash(g.h, g.key, g.counter, data)
+	} else {
+		k = DoubleHash(g.h, g.key, data)
+	}
+	// The digest is truncated when the key is shorter than the hash output.
+	copy(g.key, k)
+	Wipe(k)
+	g.incrCounter()
 	g.initialized = true
 	return len(data), nil
 }
 
+// WriteString is the same as Write([]byte(s)) without copying s.
+func (g *generator) WriteString(s string) (int, error) {
+	// The hash doesn't modify nor retain the slice so it is safe to alias the
+	// string's memory.
+	return g.Write(unsafe.Slice(unsafe.StringData(s), len(s)))
+}
+
 // generateBlocks generates a number of blocks of random output into |out|.
 //
 // It generates random data by running in AES in CTR mode.
 func (g *generator) generateBlocks(c cipher.Block, out []byte) {
 	// Lock must be held by the caller.
+	g.generate(c, out, g.continuousTest)
+}
+
+// rekey replaces the key with the next blocks of the key stream. They are not
+// passed to check so lastBlock never holds key material.
+//
+// Lock must be held by the caller.
+func (g *generator) rekey(c cipher.Block) {
+	g.generate(c, g.key, false)
+}
+
+// generate implements generateBlocks. check selects if the blocks are passed
+// to check.
+func (g *generator) generate(c cipher.Block, out []byte, check bool) {
+	// Lock must be held by the caller.
+	if g.bigEndian {
+		g.generateBlocksCTR(c, out, check)
+		return
+	}
 	// Recall that c.BlockSize() == g.h.Size() / 2
 	s := c.BlockSize()
 	fullBlocks := len(out) / s
@@ -103,6 +212,9 @@ func (g *generator) generateBlocks(c cipher.Block, out []byte) {
 		b := i * s
 		c.Encrypt(out[b:b+s], g.counter)
 		g.counter.incr()
+		if check {
+			g.check(out[b : b+s])
+		}
 	}
 	// Generates the last partial block in a temporary slice so only the bytes
 	// needed can be put in the buffer.
@@ -111,9 +223,100 @@ func (g *generator) generateBlocks(c cipher.Block, out []byte) {
 		c.Encrypt(g.temp, g.counter)
 		copy(out[fullBlocks*s:], g.temp)
 		g.counter.incr()
+		if check {
+			g.check(g.temp[:s])
+		}
 	}
 }
 
+// generateBlocksCTR is the same as generateBlocks except that it uses
+// cipher.NewCTR, so the counter is incremented as a big endian integer. The
+// last partial block consumes a full block of key stream.
+func (g *generator) generateBlocksCTR(c cipher.Block, out []byte, check bool) {
+	// Lock must be held by the caller.
+	s := c.BlockSize()
+	full := len(out) / s * s
+	n := full / s
+	stream := cipher.NewCTR(c, g.counter)
+	// XORing zeros returns the key stream.
+	Wipe(out[:full])
+	stream.XORKeyStream(out[:full], out[:full])
+	if full != len(out) {
+		b := g.temp[:s]
+		Wipe(b)
+		stream.XORKeyStream(b, b)
+		copy(out[full:], b)
+		n++
+	}
+	if check {
+		for i := 0; i < full; i += s {
+			g.check(out[i : i+s])
+		}
+		if full != len(out) {
+			g.check(g.temp[:s])
+		}
+	}
+	g.counter.addBE(uint64(n))
+}
+
+// incrCounter adds 1 to the counter with the endianness of the mode.
+//
+// Lock must be held by the caller.
+func (g *generator) incrCounter() {
+	if g.bigEndian {
+		g.counter.addBE(1)
+	} else {
+		g.counter.incr()
+	}
+}
+
+// check sets stuck if block is the same as the previous block.
+//
+// Lock must be held by the caller.
+func (g *generator) check(block []byte) {
+	if g.lastBlock == nil {
+		g.lastBlock = make([]byte, len(block))
+	} else if bytes.Equal(block, g.lastBlock) {
+		g.stuck = true
+	}
+	copy(g.lastBlock, block)
+}
+
+// requestSize returns the maximum size of the next request.
+//
+// It is lowered when the counter would wrap during the request so the wrap
+// happens right after the rekey. This way a single key never encrypts counter
+// values from both sides of the wrap, which guarantees that a counter value is
+// never reused with the same key. The wrap can't be avoided when the counter
+// is already within the rekey blocks of the wrap; this only happens if the
+// counter was explicitly set there.
+//
+// Lock must be held by the caller.
+func (g *generator) requestSize() int {
+	m := g.maxBytesPerRequest
+	keyBlocks := uint64((len(g.key) + aes.BlockSize - 1) / aes.BlockSize)
+	w := g.counter.untilWrap()
+	if g.bigEndian {
+		w = g.counter.untilWrapBE()
+	}
+	if w > keyBlocks && w-keyBlocks < uint64(m/aes.BlockSize) {
+		m = int(w-keyBlocks) * aes.BlockSize
+	}
+	return m
+}
+
+// BytesUntilRekey returns the maximum number of bytes the next Read returns
+// before the generator rekeys.
+//
+// Since the key is replaced at the end of every Read, no bytes are ever
+// produced with the current key yet, so this is maxBytesPerRequest except
+// right before the counter wraps; see requestSize.
+func (g *generator) BytesUntilRekey() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.requestSize()
+}
+
 // Read reads pseudorandom data from the generator.
 //
 // A single Read reads at most maxBytesPerRequest bytes.
@@ -125,8 +328,11 @@ func (g *generator) Read(data []byte) (int, error) {
 	if !g.initialized {
 		return 0, errors.New("Generator is not seeded")
 	}
+	if g.stuck {
+		return 0, ErrStuckGenerator
+	}
 
-	if len(data) > g.maxBytesPerRequest {
+	if m := g.requestSize(); len(data) > m {
 		// The following description assumes using SHA-256:
 		// p. 143
 		// If we were to generate 2⁶⁴ blocks of output from a single key, we would
@@ -139,12 +345,12 @@ func (g *generator) Read(data []byte) (int, error) {
 		// would not be detectable until about 2⁹⁷ requests had been made. The total
 		// workload for the attacker ends up being 2¹¹³ steps. Not quite the 2¹²⁸
 		// steps that we're aiming for, but reasonably close.
-		data = data[:g.maxBytesPerRequest]
+		data = data[:m]
 	}
 	// AES-128 or AES-256 will be selected depending on the key size:
 	// - len(g.key) == 16 -> AES-128
 	// - len(g.key) == 32 -> AES-256
-	c, err := aes.NewCipher(g.key)
+	c, err := g.newCipher(g.key)
 	if err != nil {
 		panic(err) // Only possible error is bad key size.
 	}
@@ -157,6 +363,73 @@ func (g *generator) Read(data []byte) (int, error) {
 	// generate an extra 256 bits of pseudorandom data and use that as the new
 	// key for the block cipher. We can then forget the old key, thereby
 	// eliminating any possibility of leaking information about old requests.
-	g.generateBlocks(c, g.key)
+	if g.forwardSecure {
+		g.rekey(c)
+	}
+	if g.stuck {
+		Wipe(data)
+		return 0, ErrStuckGenerator
+	}
+	g.generated += uint64(len(data))
 	return len(data), nil
 }
+
+// readVectored fills each buffer of bufs in turn, as if they were a single
+// contiguous buffer read with successive Read calls, while taking the lock
+// only once.
+func (g *generator) readVectored(bufs [][]byte) (int, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if !g.initialized {
+		return 0, errors.New("Generator is not seeded")
+	}
+	if g.stuck {
+		return 0, ErrStuckGenerator
+	}
+	total := 0
+	// bufs[i][off:] is the remaining part to fill.
+	i, off := 0, 0
+	for i != len(bufs) {
+		c, err := g.newCipher(g.key)
+		if err != nil {
+			panic(err) // Only possible error is bad key size.
+		}
+		// One request, up to maxBytesPerRequest bytes, possibly spanning multiple
+		// buffers.
+		s := c.BlockSize()
+		m := g.requestSize()
+		var left []byte // Unused part of the last partially used block.
+		for n := 0; n < m && i != len(bufs); {
+			b := bufs[i][off:]
+			if len(b) > m-n {
+				b = b[:m-n]
+			}
+			k := copy(b, left)
+			left = left[k:]
+			full := k + (len(b)-k)/s*s
+			g.generateBlocks(c, b[k:full])
+			if full != len(b) {
+				g.generateBlocks(c, g.temp[:s])
+				left = g.temp[copy(b[full:], g.temp):s]
+			}
+			n += len(b)
+			total += len(b)
+			if off += len(b); off == len(bufs[i]) {
+				i, off = i+1, 0
+			}
+		}
+		// See Read() for the rationale.
+		if g.forwardSecure {
+			g.rekey(c)
+		}
+		if g.stuck {
+			for _, b := range bufs {
+				Wipe(b)
+			}
+			return 0, ErrStuckGenerator
+		}
+	}
+	g.generated += uint64(total)
+	return total, nil
+}
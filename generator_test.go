@@ -13,6 +13,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"hash"
 	"io"
 	"io/ioutil"
@@ -47,7 +48,7 @@ func init() {
 	}
 }
 
-func TestnewGeneratorDefault(t *testing.T) {
+func TestNewGeneratorDefault(t *testing.T) {
 	t.Parallel()
 	g := newGenerator(sha256.New(), nil)
 	if g.h.Size() != 32 {
@@ -109,6 +110,16 @@ func testGeneratorLen(t *testing.T, i int, s generatorLenTest) {
 	}
 }
 
+func TestGeneratorSecurityBits(t *testing.T) {
+	t.Parallel()
+	if b := newGenerator(nil, nil).SecurityBits(); b != 128 {
+		t.Fatalf("Got %d", b)
+	}
+	if b := newGenerator(md5.New(), nil).SecurityBits(); b != 64 {
+		t.Fatalf("Got %d", b)
+	}
+}
+
 func TestGeneratorCutShort(t *testing.T) {
 	t.Parallel()
 	// This test is CPU intensive so parallelize as much as possible.
@@ -177,6 +188,374 @@ func TestGeneratorDeterminism(t *testing.T) {
 	}
 }
 
+func TestGeneratorReadVectored(t *testing.T) {
+	t.Parallel()
+	sizes := []int{3, 16, 0, 29, 1, 100}
+	data := []struct {
+		maxBytesPerRequest int
+		reads              []int
+	}{
+		// Fits in a single request.
+		{1 << 20, []int{149}},
+		// Spans 3 requests.
+		{64, []int{64, 64, 21}},
+	}
+	for i, line := range data {
+		g1 := newGenerator(nil, []byte{0})
+		g1.maxBytesPerRequest = line.maxBytesPerRequest
+		var expected []byte
+		for _, l := range line.reads {
+			d := make([]byte, l)
+			read(t, g1, d, l)
+			expected = append(expected, d...)
+		}
+
+		g2 := newGenerator(nil, []byte{0})
+		g2.maxBytesPerRequest = line.maxBytesPerRequest
+		bufs := make([][]byte, len(sizes))
+		for j, l := range sizes {
+			bufs[j] = make([]byte, l)
+		}
+		n, err := g2.readVectored(bufs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(expected) {
+			t.Fatalf("%d: Got %d", i, n)
+		}
+		if actual := bytes.Join(bufs, nil); !bytes.Equal(expected, actual) {
+			t.Fatalf("%d: %v != %v", i, actual, expected)
+		}
+		// The generators are in the same state.
+		d1 := make([]byte, 16)
+		read(t, g1, d1, 16)
+		d2 := make([]byte, 16)
+		read(t, g2, d2, 16)
+		if !bytes.Equal(d1, d2) {
+			t.Fatalf("%d: %v != %v", i, d1, d2)
+		}
+	}
+	if _, err := newGenerator(nil, nil).readVectored([][]byte{make([]byte, 1)}); err == nil {
+		t.Fatal("No error set")
+	}
+}
+
+func TestGeneratorNotForwardSecure(t *testing.T) {
+	t.Parallel()
+	g := newGenerator(nil, []byte{0})
+	g.forwardSecure = false
+	key := append([]byte{}, g.key...)
+	counter := append(counter{}, g.counter...)
+	// The partial block of the first read is discarded.
+	d := make([]byte, 80+32)
+	read(t, g, d[:70], 70)
+	read(t, g, d[80:], 32)
+	if !bytes.Equal(key, g.key) {
+		t.Fatal("The key changed")
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each block must be AES-CTR with the little endian counter as the IV.
+	expected := make([]byte, len(d))
+	for i := 0; i < len(expected); i += aes.BlockSize {
+		cipher.NewCTR(c, counter).XORKeyStream(expected[i:i+aes.BlockSize], expected[i:i+aes.BlockSize])
+		counter.incr()
+	}
+	if !bytes.Equal(expected[:70], d[:70]) || !bytes.Equal(expected[80:], d[80:]) {
+		t.Fatalf("%v != %v", d, expected)
+	}
+}
+
+func TestGeneratorMixCounter(t *testing.T) {
+	t.Parallel()
+	newMixed := func() *generator {
+		g := newGenerator(nil, nil)
+		g.mixCounter = true
+		_, _ = g.Write([]byte{0})
+		_, _ = g.Write([]byte{1})
+		return g
+	}
+	g := newMixed()
+	// The key is derived from the previous key, the counter before the
+	// increment and the seed.
+	key := DoubleHash(sha256.New(), make([]byte, 32), make([]byte, 16), []byte{0})
+	c := make([]byte, 16)
+	c[0] = 1
+	key = DoubleHash(sha256.New(), key, c, []byte{1})
+	if !bytes.Equal(key, g.key) {
+		t.Fatalf("%x != %x", g.key, key)
+	}
+	def := newGenerator(nil, []byte{0})
+	_, _ = def.Write([]byte{1})
+	actual := make([]byte, 32)
+	read(t, g, actual, 32)
+	expected := make([]byte, 32)
+	read(t, def, expected, 32)
+	if bytes.Equal(expected, actual) {
+		t.Fatal("MixCounter didn't change the output")
+	}
+	read(t, newMixed(), expected, 32)
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+}
+
+func TestGeneratorCTRCompat(t *testing.T) {
+	t.Parallel()
+	newCompat := func() *generator {
+		g := newGenerator(nil, nil)
+		g.bigEndian = true
+		_, _ = g.Write([]byte{0})
+		return g
+	}
+	// The vectors of both modes from the same seed.
+	data := []struct {
+		bigEndian bool
+		expected  string
+	}{
+		{false, "adb360869ee94b4f23e8cf564976138377c48456b15d4bafe9817104c138de75640b806c508e060c"},
+		{true, "8627579f0013e29d61fca4affa9df9ae1b448c79ed9baff134a825080ec11bed2da285b07fabd950"},
+	}
+	for _, line := range data {
+		g := newGenerator(nil, []byte{0})
+		if line.bigEndian {
+			g = newCompat()
+		}
+		d := make([]byte, 40)
+		read(t, g, d, len(d))
+		if e := decodeString(line.expected); !bytes.Equal(d, e) {
+			t.Fatalf("%t: %x != %x", line.bigEndian, d, e)
+		}
+	}
+
+	// The output is the key stream of cipher.NewCTR with the big endian counter
+	// as the IV.
+	g := newCompat()
+	g.forwardSecure = false
+	iv := make([]byte, 16)
+	iv[15] = 1
+	if !bytes.Equal(iv, g.counter) {
+		t.Fatalf("%x", g.counter)
+	}
+	c, err := aes.NewCipher(g.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The partial block of the first read is discarded.
+	d := make([]byte, 80+32)
+	read(t, g, d[:70], 70)
+	read(t, g, d[80:], 32)
+	expected := make([]byte, len(d))
+	cipher.NewCTR(c, iv).XORKeyStream(expected, expected)
+	if !bytes.Equal(expected[:70], d[:70]) || !bytes.Equal(expected[80:], d[80:]) {
+		t.Fatalf("%x != %x", d, expected)
+	}
+
+	// readVectored returns the same output as Read.
+	g = newCompat()
+	read(t, g, expected[:70], 70)
+	g = newCompat()
+	if n, err := g.readVectored([][]byte{d[:3], d[3:40], d[40:70]}); n != 70 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if !bytes.Equal(expected[:70], d[:70]) {
+		t.Fatalf("%x != %x", d[:70], expected[:70])
+	}
+}
+
+func TestCTRCompatOption(t *testing.T) {
+	t.Parallel()
+	f, err := NewFortunaWithOptions(make([]byte, 128), Options{CTRCompat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := NewFortunaWithOptions(make([]byte, 128), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := make([]byte, 32)
+	read(t, f, a, len(a))
+	b := make([]byte, 32)
+	read(t, def, b, len(b))
+	if bytes.Equal(a, b) {
+		t.Fatal("CTRCompat didn't change the output")
+	}
+}
+
+func TestGeneratorCounterWrap(t *testing.T) {
+	t.Parallel()
+	g := newGenerator(nil, []byte{0})
+	// 10 blocks before the wrap.
+	for i := range g.counter {
+		g.counter[i] = 255
+	}
+	g.counter[0] = 246
+	data := make([]byte, 1000)
+	// 2 blocks are kept for the new key.
+	read(t, g, data, 8*aes.BlockSize)
+	if !bytes.Equal(g.counter, make(counter, 16)) {
+		t.Fatalf("Got %v", g.counter)
+	}
+	read(t, g, data, len(data))
+
+	// ReadVectored splits the request at the wrap.
+	g.counter[0] = 246
+	for i := 1; i < len(g.counter); i++ {
+		g.counter[i] = 255
+	}
+	if n, err := g.readVectored([][]byte{data[:100], data[100:]}); n != len(data) || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	// 872 bytes in 55 blocks in the second request, plus 2 blocks for the rekey.
+	if expected := (counter{57, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); !bytes.Equal(g.counter, expected) {
+		t.Fatalf("%v != %v", g.counter, expected)
+	}
+}
+
+func TestGeneratorBytesUntilRekey(t *testing.T) {
+	t.Parallel()
+	g := newGenerator(nil, []byte{0})
+	if n := g.BytesUntilRekey(); n != g.maxBytesPerRequest {
+		t.Fatalf("Got %d", n)
+	}
+	// The read is a complete request so the generator is rekeyed.
+	read(t, g, make([]byte, 100), 100)
+	if n := g.BytesUntilRekey(); n != g.maxBytesPerRequest {
+		t.Fatalf("Got %d", n)
+	}
+	// 10 blocks before the wrap, 2 are kept for the new key.
+	for i := range g.counter {
+		g.counter[i] = 255
+	}
+	g.counter[0] = 246
+	if n := g.BytesUntilRekey(); n != 8*aes.BlockSize {
+		t.Fatalf("Got %d", n)
+	}
+	read(t, g, make([]byte, 1000), 8*aes.BlockSize)
+	if n := g.BytesUntilRekey(); n != g.maxBytesPerRequest {
+		t.Fatalf("Got %d", n)
+	}
+}
+
+func TestGeneratorWriteString(t *testing.T) {
+	t.Parallel()
+	g1 := newGenerator(nil, []byte{0})
+	g2 := newGenerator(nil, []byte{0})
+	if n, err := g1.WriteString("hostname"); n != 8 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	_, _ = g2.Write([]byte("hostname"))
+	// Also used by io.WriteString.
+	if _, err := io.WriteString(g1, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = g2.Write(nil)
+	actual := make([]byte, 32)
+	read(t, g1, actual, len(actual))
+	expected := make([]byte, 32)
+	read(t, g2, expected, len(expected))
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("%v != %v", actual, expected)
+	}
+}
+
+// shortHash is a broken hash whose digest is shorter than its Size.
+type shortHash struct {
+	hash.Hash
+}
+
+func (s shortHash) Sum(b []byte) []byte {
+	return s.Hash.Sum(b)[:len(b)+s.Size()-1]
+}
+
+func TestNewGeneratorBadHash(t *testing.T) {
+	t.Parallel()
+	if err := checkHash(sha256.New()); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkHash(shortHash{sha256.New()}); err == nil {
+		t.Fatal("No error set")
+	}
+	if g, err := NewGeneratorChecked(shortHash{sha256.New()}, nil); g != nil || err == nil {
+		t.Fatalf("Got %v, %v", g, err)
+	}
+	if g, err := NewGeneratorChecked(nil, []byte{0}); g == nil || err != nil {
+		t.Fatalf("Got %v, %v", g, err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewGenerator didn't panic")
+		}
+	}()
+	NewGenerator(shortHash{sha256.New()}, nil)
+}
+
+// constantBlock is a broken cipher.Block that always returns the same block.
+type constantBlock struct{}
+
+func (constantBlock) BlockSize() int { return aes.BlockSize }
+
+func (constantBlock) Encrypt(dst, src []byte) {
+	for i := 0; i < aes.BlockSize; i++ {
+		dst[i] = 1
+	}
+}
+
+func (constantBlock) Decrypt(dst, src []byte) {
+	panic("not implemented")
+}
+
+func TestGeneratorContinuousTestKey(t *testing.T) {
+	t.Parallel()
+	prng, err := NewFortunaWithOptions(make([]byte, 128), Options{ContinuousTest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := prng.(*accumulator)
+	read(t, a, make([]byte, 32), 32)
+	// lastBlock holds the last block of output, not the new key.
+	if bytes.Equal(a.generator.lastBlock, a.generator.key[len(a.generator.key)-aes.BlockSize:]) {
+		t.Fatal("lastBlock holds key material")
+	}
+	_ = a.Close()
+	if !isZero(a.generator.lastBlock) {
+		t.Fatal("lastBlock not wiped")
+	}
+}
+
+func TestGeneratorContinuousTest(t *testing.T) {
+	t.Parallel()
+	g := newGenerator(nil, []byte{0})
+	g.continuousTest = true
+	data := make([]byte, 1000)
+	read(t, g, data, len(data))
+	if n, err := g.readVectored([][]byte{data[:10], data[10:]}); n != len(data) || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+
+	g.newCipher = func(key []byte) (cipher.Block, error) {
+		return constantBlock{}, nil
+	}
+	// Two blocks of output are enough. The rekey blocks are not compared.
+	if n, err := g.Read(data[:17]); n != 0 || err != ErrStuckGenerator {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if !isZero(data[:17]) {
+		t.Fatal("The data was not wiped")
+	}
+	// The failure is permanent.
+	g.newCipher = aes.NewCipher
+	if _, err := g.Read(data); err != ErrStuckGenerator {
+		t.Fatalf("Got %v", err)
+	}
+	if _, err := g.readVectored([][]byte{data}); err != ErrStuckGenerator {
+		t.Fatalf("Got %v", err)
+	}
+}
+
 // Benches large chunks throughput. Calculates the cost per byte.
 func BenchmarkGeneratorLarge(b *testing.B) {
 	g := NewGenerator(nil, []byte{0})
@@ -201,6 +580,23 @@ func BenchmarkGeneratorLarge(b *testing.B) {
 	}
 }
 
+// Benches large chunks throughput with the continuous test enabled.
+func BenchmarkGeneratorLargeContinuousTest(b *testing.B) {
+	g := newGenerator(nil, []byte{0})
+	g.continuousTest = true
+	data := make([]byte, b.N)
+	count := 0
+	b.ResetTimer()
+
+	for count != b.N {
+		n, err := g.Read(data[:b.N-count])
+		if err != nil {
+			b.Fatal(err)
+		}
+		count += n
+	}
+}
+
 // Reads 1 byte at a time to bench overhead. Calculates the cost per byte.
 func BenchmarkGenerator1Byte(b *testing.B) {
 	g := NewGenerator(nil, []byte{0})
@@ -241,6 +637,92 @@ func BenchmarkGenerator16Bytes(b *testing.B) {
 	}
 }
 
+// ctrKeyStream generates the blocks with a single cipher.NewCTR stream, like
+// the production big endian path. Since NewCTR increments its counter as big
+// endian, the output only matches generateBlocks in bigEndian mode.
+func ctrKeyStream(g *generator, c cipher.Block, out []byte) {
+	s := c.BlockSize()
+	n := (len(out) + s - 1) / s
+	stream := cipher.NewCTR(c, g.counter)
+	Wipe(out)
+	stream.XORKeyStream(out, out)
+	g.counter.addBE(uint64(n))
+}
+
+// generatorBackends are the implementations compared by
+// TestGeneratorBackends and BenchmarkGeneratorBackends.
+var generatorBackends = []struct {
+	name      string
+	bigEndian bool
+	f         func(g *generator, c cipher.Block, out []byte)
+}{
+	{"Loop", false, func(g *generator, c cipher.Block, out []byte) { g.generateBlocks(c, out) }},
+	{"CTR", true, ctrKeyStream},
+}
+
+func TestGeneratorBackends(t *testing.T) {
+	t.Parallel()
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []int{1, 16, 70, 4096} {
+		for _, b := range generatorBackends {
+			// Reference: encrypt each block with the counter of the backend's
+			// endianness. Make the counter carry in the middle of the output.
+			ref := newGenerator(nil, []byte{0})
+			ref.counter[0] = 250
+			ref.counter[15] = 250
+			expected := make([]byte, 0, l+16)
+			block := make([]byte, 16)
+			for len(expected) < l {
+				c.Encrypt(block, ref.counter)
+				expected = append(expected, block...)
+				if b.bigEndian {
+					ref.counter.addBE(1)
+				} else {
+					ref.counter.incr()
+				}
+			}
+			expected = expected[:l]
+
+			g := newGenerator(nil, []byte{0})
+			g.counter[0] = 250
+			g.counter[15] = 250
+			out := make([]byte, l)
+			b.f(g, c, out)
+			if !bytes.Equal(expected, out) {
+				t.Fatalf("%s: %d bytes: %x != %x", b.name, l, out, expected)
+			}
+			if !bytes.Equal(ref.counter, g.counter) {
+				t.Fatalf("%s: %d bytes: counter %x != %x", b.name, l, g.counter, ref.counter)
+			}
+		}
+	}
+}
+
+// Compares the throughput of generateBlocks with a cipher.NewCTR stream on the
+// current platform.
+func BenchmarkGeneratorBackends(b *testing.B) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{16, 1024, 1 << 20} {
+		for _, backend := range generatorBackends {
+			b.Run(fmt.Sprintf("%s/%d", backend.name, size), func(b *testing.B) {
+				g := newGenerator(nil, []byte{0})
+				out := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					backend.f(g, c, out)
+				}
+			})
+		}
+	}
+}
+
 func decodeString(str string) []byte {
 	d, err := hex.DecodeString(str)
 	if err != nil {
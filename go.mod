@@ -1,3 +1,3 @@
 module github.com/maruel/fortuna
 
-go 1.11
+go 1.22
@@ -0,0 +1,45 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"encoding/binary"
+	"net/http"
+	"time"
+)
+
+// Middleware returns a http middleware that adds entropy from each request to
+// f:
+//   - source 1: the time the request is received and the time the handler
+//     returns.
+//   - source 2: the remote address.
+//   - source 3: the User-Agent, Accept-Language and Referer headers.
+//
+// The handler is never blocked on the accumulator: when f is saturated, the
+// events are dropped and counted in Stats().DroppedEvents. Most of this data
+// is known to the client; the entropy comes mostly from the timing. Fortuna is
+// designed to be safe with such attacker-controlled events.
+func Middleware(f Fortuna) func(http.Handler) http.Handler {
+	add := f.AddRandomEvent
+	if a, ok := f.(*accumulator); ok {
+		add = func(source byte, data ...[]byte) {
+			a.addRandomEvent(source, data, true)
+		}
+	}
+	timing := func() {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+		add(1, b[:])
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timing()
+			add(2, []byte(r.RemoteAddr))
+			add(3, []byte(r.Header.Get("User-Agent")), []byte(r.Header.Get("Accept-Language")), []byte(r.Header.Get("Referer")))
+			next.ServeHTTP(w, r)
+			timing()
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	called := 0
+	h := Middleware(a)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+	}))
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", "test")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if called != 3 {
+		t.Fatalf("Got %d", called)
+	}
+	a.Flush()
+	// 4 events per request.
+	if a.nextPool != 12 {
+		t.Fatalf("Got %d", a.nextPool)
+	}
+	for i := 0; i < 12; i++ {
+		if a.pools[i].length == 0 {
+			t.Fatalf("Pool %d is empty", i)
+		}
+	}
+}
+
+func TestMiddlewareSaturated(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{MaxEventGoroutines: 1})
+	h := Middleware(a)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Hold the lock so the first event keeps its goroutine busy; the handler
+	// must not block on the other ones.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("User-Agent", "test")
+	a.lock.Lock()
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	a.lock.Unlock()
+	a.Flush()
+	if s := a.Stats(); s.DroppedEvents != 3 || s.NextPool != 1 {
+		t.Fatalf("Got %+v", s)
+	}
+}
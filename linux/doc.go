@@ -0,0 +1,11 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package linux exposes a Fortuna instance to other processes on Linux.
+//
+// ServeFIFO creates a named pipe that legacy programs can read like
+// /dev/urandom. It is not a real character device: the data is split among
+// the concurrent readers and they get EOF once ServeFIFO returns. Exposing it
+// through FUSE or CUSE would require a third party package.
+package linux
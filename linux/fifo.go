@@ -0,0 +1,82 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build linux
+
+package linux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/maruel/fortuna"
+)
+
+// ServeFIFO creates a named pipe at path and writes random data read from f
+// to it, as an endless stream, until ctx is done or f fails. The pipe is
+// removed on return. It is only accessible by the current user; use os.Chmod
+// to share it.
+//
+// When all the readers closed the pipe, it waits for the next one. It returns
+// ctx.Err() when ctx is done.
+func ServeFIFO(ctx context.Context, f fortuna.Fortuna, path string) error {
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	buf := make([]byte, 32*1024)
+	defer fortuna.Wipe(buf)
+	for {
+		w, err := openWriter(ctx, path)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			_ = w.Close()
+			return err
+		}
+		// Closing the pipe unblocks a write to a reader that doesn't read.
+		stop := context.AfterFunc(ctx, func() { _ = w.Close() })
+		err = serve(w, f, buf)
+		stop()
+		_ = w.Close()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !errors.Is(err, syscall.EPIPE) {
+			return err
+		}
+	}
+}
+
+// openWriter opens the pipe for writing, which blocks until a reader opens
+// it, or until ctx is done.
+func openWriter(ctx context.Context, path string) (*os.File, error) {
+	opened := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		// Act as a reader until the open returned so it can't miss it.
+		if r, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			<-opened
+			_ = r.Close()
+		}
+	})
+	defer stop()
+	defer close(opened)
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
+
+// serve writes data from f to w until either fails.
+func serve(w io.Writer, f fortuna.Fortuna, buf []byte) error {
+	for {
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
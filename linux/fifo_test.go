@@ -0,0 +1,94 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build linux
+
+package linux
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maruel/fortuna"
+)
+
+func TestServeFIFO(t *testing.T) {
+	t.Parallel()
+	f, err := fortuna.NewFortuna(make([]byte, fortuna.MinSeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "myrandom")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ServeFIFO(ctx, f, p)
+	}()
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if fi, err := os.Stat(p); err == nil {
+			if m := fi.Mode().Perm(); m&0o077 != 0 {
+				t.Fatalf("Got %s", m)
+			}
+			break
+		}
+		select {
+		case err := <-done:
+			t.Skipf("Can't create a named pipe: %v", err)
+		default:
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("The pipe was not created")
+		}
+	}
+
+	// Concurrent readers get different data.
+	var wg sync.WaitGroup
+	data := make([][]byte, 2)
+	for i := range data {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := os.Open(p)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer r.Close()
+			data[i] = make([]byte, 16*1000)
+			if _, err := io.ReadFull(r, data[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	all := append(data[0], data[1]...)
+	if err := fortuna.CollisionTest(bytes.NewReader(all), len(all)/16, 16); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new reader after the previous ones closed the pipe.
+	r, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	_ = r.Close()
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Got %v", err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("Got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// passphraseIterations is the PBKDF2 work factor used by DeriveFromPassphrase.
+const passphraseIterations = 600000
+
+// DeriveFromPassphrase returns outLen bytes derived from a passphrase and a
+// salt, e.g. to generate reproducible keys from a passphrase.
+//
+// The passphrase is first stretched with PBKDF2-HMAC-SHA256 with 600000
+// iterations, which deliberately makes each guess slow, then the result seeds
+// a generator that returns the output. The output is only as strong as the
+// passphrase; the salt should be unique per use.
+func DeriveFromPassphrase(pass, salt []byte, outLen int) ([]byte, error) {
+	return deriveFromPassphrase(pass, salt, outLen, passphraseIterations)
+}
+
+func deriveFromPassphrase(pass, salt []byte, outLen, iterations int) ([]byte, error) {
+	if outLen <= 0 {
+		return nil, errors.New("invalid argument to DeriveFromPassphrase")
+	}
+	seed := pbkdf2SHA256(pass, salt, iterations, sha256.Size)
+	defer Wipe(seed)
+	out := make([]byte, outLen)
+	if _, err := io.ReadFull(NewGenerator(nil, seed), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// pbkdf2SHA256 implements PBKDF2 from RFC 8018 with HMAC-SHA256 as the PRF.
+func pbkdf2SHA256(pass, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, pass)
+	out := make([]byte, 0, keyLen+sha256.Size)
+	var idx [4]byte
+	u := make([]byte, 0, sha256.Size)
+	t := make([]byte, sha256.Size)
+	for block := uint32(1); len(out) < keyLen; block++ {
+		binary.BigEndian.PutUint32(idx[:], block)
+		prf.Reset()
+		_, _ = prf.Write(salt)
+		_, _ = prf.Write(idx[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			_, _ = prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	Wipe(u)
+	Wipe(t)
+	return out[:keyLen]
+}
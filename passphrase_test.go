@@ -0,0 +1,69 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	t.Parallel()
+	// From RFC 7914 section 11.
+	data := []struct {
+		pass, salt string
+		iterations int
+		expected   string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for i, line := range data {
+		actual := pbkdf2SHA256([]byte(line.pass), []byte(line.salt), line.iterations, 64)
+		if e := decodeString(line.expected); !bytes.Equal(actual, e) {
+			t.Fatalf("%d: %x != %x", i, actual, e)
+		}
+	}
+}
+
+func TestDeriveFromPassphrase(t *testing.T) {
+	t.Parallel()
+	k1, err := deriveFromPassphrase([]byte("pass"), []byte("salt1"), 40, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := deriveFromPassphrase([]byte("pass"), []byte("salt1"), 40, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k1, k2) {
+		t.Fatalf("%x != %x", k1, k2)
+	}
+	k3, err := deriveFromPassphrase([]byte("pass"), []byte("salt2"), 40, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(k1, k3) {
+		t.Fatal("Different salts returned the same key")
+	}
+	// The output is the generator seeded with the PBKDF2 output.
+	expected := make([]byte, 40)
+	g := newGenerator(nil, pbkdf2SHA256([]byte("pass"), []byte("salt1"), 10, 32))
+	read(t, g, expected, 40)
+	if !bytes.Equal(k1, expected) {
+		t.Fatalf("%x != %x", k1, expected)
+	}
+	// The real work factor.
+	k, err := DeriveFromPassphrase([]byte("pass"), []byte("salt1"), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k) != 16 || bytes.Equal(k, k1[:16]) {
+		t.Fatalf("Got %x", k)
+	}
+	if _, err := DeriveFromPassphrase([]byte("pass"), []byte("salt1"), 0); err == nil {
+		t.Fatal("No error set")
+	}
+}
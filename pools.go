@@ -0,0 +1,156 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"crypto/aes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// poolsVersion is the first byte of the data returned by MarshalPools. It is
+// followed by numReseed and nextPool as uint64, then for each pool its length
+// and drain count as uint64, its flags and its hash state prefixed by its
+// length as uint32. All integers are little endian.
+const poolsVersion = 1
+
+// Flags of each pool in the data returned by MarshalPools.
+const (
+	poolHasEvents = 1 << iota
+	poolClean
+)
+
+func (a *accumulator) MarshalPools() ([]byte, error) {
+	// Wait for the pending events so the snapshot includes them.
+	a.Flush()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return nil, ErrClosed
+	}
+	out := []byte{poolsVersion}
+	out = binary.LittleEndian.AppendUint64(out, uint64(a.numReseed))
+	out = binary.LittleEndian.AppendUint64(out, uint64(a.nextPool))
+	for i := range a.pools {
+		m, ok := a.pools[i].Hash.(encoding.BinaryMarshaler)
+		if !ok {
+			return nil, errors.New("the pool hash doesn't implement encoding.BinaryMarshaler")
+		}
+		state, err := m.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = binary.LittleEndian.AppendUint64(out, uint64(a.pools[i].length))
+		out = binary.LittleEndian.AppendUint64(out, uint64(a.drains[i]))
+		flags := byte(0)
+		if a.hasEvents[i] {
+			flags |= poolHasEvents
+		}
+		if a.clean[i] {
+			flags |= poolClean
+		}
+		out = append(out, flags)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(state)))
+		out = append(out, state...)
+		Wipe(state)
+	}
+	return out, nil
+}
+
+func (a *accumulator) UnmarshalPools(data []byte) error {
+	if len(data) < 17 || data[0] != poolsVersion {
+		return errors.New("invalid pools data")
+	}
+	numReseed := binary.LittleEndian.Uint64(data[1:])
+	nextPool := binary.LittleEndian.Uint64(data[9:])
+	if nextPool >= numPools || numReseed > 1<<62 {
+		return errors.New("invalid pools data")
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	// Decode in temporary hashes so a failure leaves the pools untouched.
+	var pools [numPools]countedHash
+	var drains [numPools]int
+	var flags [numPools]byte
+	d := data[17:]
+	for i := range pools {
+		if len(d) < 21 {
+			return errors.New("invalid pools data")
+		}
+		pools[i].length = int(binary.LittleEndian.Uint64(d))
+		drains[i] = int(binary.LittleEndian.Uint64(d[8:]))
+		flags[i] = d[16]
+		l := binary.LittleEndian.Uint32(d[17:])
+		d = d[21:]
+		if uint64(len(d)) < uint64(l) {
+			return errors.New("invalid pools data")
+		}
+		pools[i].Hash = a.newPoolHash()
+		u, ok := pools[i].Hash.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return errors.New("the pool hash doesn't implement encoding.BinaryUnmarshaler")
+		}
+		if err := u.UnmarshalBinary(d[:l]); err != nil {
+			return fmt.Errorf("invalid state for pool %d: %w", i, err)
+		}
+		d = d[l:]
+	}
+	if len(d) != 0 {
+		return errors.New("invalid pools data")
+	}
+	a.pools = pools
+	a.drains = drains
+	for i, f := range flags {
+		a.hasEvents[i] = f&poolHasEvents != 0
+		a.clean[i] = f&poolClean != 0
+	}
+	a.numReseed = int(numReseed)
+	a.nextPool = int(nextPool)
+	return nil
+}
+
+func (a *accumulator) Validate() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return ErrClosed
+	}
+	if a.numReseed < 0 {
+		return fmt.Errorf("invalid reseed count %d", a.numReseed)
+	}
+	if a.nextPool < 0 || a.nextPool >= numPools {
+		return fmt.Errorf("invalid next pool %d", a.nextPool)
+	}
+	for i := range a.pools {
+		if a.pools[i].Hash == nil {
+			return fmt.Errorf("pool %d has no hash", i)
+		}
+		if a.pools[i].length < 0 {
+			return fmt.Errorf("pool %d has an invalid length %d", i, a.pools[i].length)
+		}
+		if a.drains[i] < 0 {
+			return fmt.Errorf("pool %d has an invalid drain count %d", i, a.drains[i])
+		}
+	}
+	a.generator.lock.Lock()
+	defer a.generator.lock.Unlock()
+	// SetGenerator may change the key size so only check it is valid.
+	k := len(a.generator.key)
+	if k != 16 && k != 32 {
+		return fmt.Errorf("invalid key size %d, must be 16 or 32", k)
+	}
+	if s := a.generator.h.Size(); s < k {
+		return fmt.Errorf("the generator hash size %d is smaller than the key size %d", s, k)
+	}
+	if l := len(a.generator.counter); l != aes.BlockSize {
+		return fmt.Errorf("the counter is %d bytes but the block size is %d", l, aes.BlockSize)
+	}
+	return nil
+}
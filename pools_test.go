@@ -0,0 +1,143 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestMarshalPools(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	for i := 0; i < 40; i++ {
+		prng.AddRandomEventSync(1, []byte{byte(i)})
+	}
+	prng.ForceReseed()
+	prng.AddRandomEventSync(1, []byte{1, 2, 3})
+	data, err := prng.MarshalPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := newFortuna(t)
+	key := append([]byte{}, other.generator.key...)
+	if err := other.UnmarshalPools(data); err != nil {
+		t.Fatal(err)
+	}
+	if a, e := other.Stats().PoolLengths, prng.Stats().PoolLengths; a != e {
+		t.Fatalf("%v != %v", a, e)
+	}
+	if a, e := other.PoolDrainCounts(), prng.PoolDrainCounts(); a != e {
+		t.Fatalf("%v != %v", a, e)
+	}
+	if other.numReseed != prng.numReseed || other.nextPool != prng.nextPool || other.hasEvents != prng.hasEvents || other.clean != prng.clean {
+		t.Fatal("Bookkeeping not restored")
+	}
+	for i := range prng.pools {
+		if a, e := other.pools[i].Sum(nil), prng.pools[i].Sum(nil); !bytes.Equal(a, e) {
+			t.Fatalf("Pool %d: %x != %x", i, a, e)
+		}
+	}
+	// The generator is not restored.
+	if !bytes.Equal(key, other.generator.key) {
+		t.Fatal("The generator changed")
+	}
+	if d, err := other.MarshalPools(); err != nil || !bytes.Equal(d, data) {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestMarshalPoolsFlush(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	p := prng.nextPool
+	before := prng.Stats().PoolLengths[p]
+	prng.AddRandomEvent(1, []byte{1, 2, 3})
+	data, err := prng.MarshalPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pending event is part of the snapshot.
+	other := newFortuna(t)
+	if err := other.UnmarshalPools(data); err != nil {
+		t.Fatal(err)
+	}
+	if l := other.Stats().PoolLengths[p]; l != before+5 {
+		t.Fatalf("Got %d", l)
+	}
+}
+
+func TestUnmarshalPoolsInvalid(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	data, err := prng.MarshalPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lengths := prng.Stats().PoolLengths
+	for i, d := range [][]byte{nil, {2}, data[:len(data)-1], append(data, 0)} {
+		if err := prng.UnmarshalPools(d); err == nil {
+			t.Fatalf("%d: No error set", i)
+		}
+	}
+	if l := prng.Stats().PoolLengths; l != lengths {
+		t.Fatalf("%v != %v", l, lengths)
+	}
+	_ = prng.Close()
+	if _, err := prng.MarshalPools(); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	if err := prng.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := prng.MarshalPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the length of pool 0 so it becomes negative.
+	binary.LittleEndian.PutUint64(data[17:], ^uint64(0))
+	if err := prng.UnmarshalPools(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := prng.Validate(); err == nil || !strings.Contains(err.Error(), "pool 0 has an invalid length -1") {
+		t.Fatalf("Got %v", err)
+	}
+
+	other := newFortuna(t)
+	other.generator.key = other.generator.key[:24]
+	if err := other.Validate(); err == nil || err.Error() != "invalid key size 24, must be 16 or 32" {
+		t.Fatalf("Got %v", err)
+	}
+	_ = other.Close()
+	if err := other.Validate(); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestValidateSetGenerator(t *testing.T) {
+	t.Parallel()
+	prng, err := NewFortunaWithOptions(make([]byte, 128), Options{KeySize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prng.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// The key size becomes the one of the new generator.
+	if err := prng.SetGenerator(NewGenerator(nil, []byte("seed"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := prng.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand/v2"
+	"sync"
+)
+
+// readUint64 reads 8 bytes of random data from r as a little endian integer.
+func readUint64(r io.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// uint64n returns a uniformly distributed value in [0, n) from r. n must not
+// be 0.
+//
+// It uses Lemire's multiply-and-reject method, the same used by
+// math/rand/v2.Uint64N, so in the common case a single 64 bits draw is needed
+// and no division is done.
+func uint64n(r io.Reader, n uint64) (uint64, error) {
+	x, err := readUint64(r)
+	if err != nil {
+		return 0, err
+	}
+	hi, lo := bits.Mul64(x, n)
+	if lo < n {
+		// Only values of lo below 2^64 % n are biased; reject them.
+		thresh := -n % n
+		for lo < thresh {
+			if x, err = readUint64(r); err != nil {
+				return 0, err
+			}
+			hi, lo = bits.Mul64(x, n)
+		}
+	}
+	return hi, nil
+}
+
+// uint64 reads 8 bytes of random data as a little endian integer.
+func (a *accumulator) uint64() (uint64, error) {
+	return readUint64(a)
+}
+
+// Uint64n returns a uniformly distributed value in [0, n).
+func (a *accumulator) Uint64n(n uint64) (uint64, error) {
+	if n == 0 {
+		return 0, errors.New("invalid argument to Uint64n")
+	}
+	return uint64n(a, n)
+}
+
+// Int returns a non-negative random int, like math/rand.Int.
+func (a *accumulator) Int() (int, error) {
+	x, err := a.uint64()
+	if err != nil {
+		return 0, err
+	}
+	// Mask the sign bit, independently of the platform int size.
+	return int(uint(x) << 1 >> 1), nil
+}
+
+// Intn returns a uniformly distributed value in [0, n), like math/rand.Intn.
+// It panics if n <= 0.
+func (a *accumulator) Intn(n int) (int, error) {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	v, err := a.Uint64n(uint64(n))
+	return int(v), err
+}
+
+// int63CacheSize is the number of values of Int63 drawn per generator
+// request.
+const int63CacheSize = 64
+
+// int63Cache holds values drawn in advance for Int63.
+type int63Cache struct {
+	lock sync.Mutex
+	buf  [int63CacheSize * 8]byte
+	left int // Number of unused bytes at the end of buf
+}
+
+// next returns the next value, refilling the cache from r when empty.
+func (c *int63Cache) next(r io.Reader) (int64, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.left == 0 {
+		if _, err := io.ReadFull(r, c.buf[:]); err != nil {
+			Wipe(c.buf[:])
+			return 0, err
+		}
+		c.left = len(c.buf)
+	}
+	b := c.buf[len(c.buf)-c.left:][:8]
+	c.left -= 8
+	v := binary.LittleEndian.Uint64(b)
+	Wipe(b)
+	return int64(v >> 1), nil
+}
+
+// wipe discards the values not yet returned.
+//
+// It must be called without holding the accumulator lock, since next holds
+// the cache lock while reading from the accumulator.
+func (c *int63Cache) wipe() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	Wipe(c.buf[:])
+	c.left = 0
+}
+
+func (a *accumulator) Int63() (int64, error) {
+	return a.int63.next(a)
+}
+
+type randSource struct {
+	f Fortuna
+}
+
+// NewRandSource returns a math/rand/v2.Source backed by f, e.g. to use
+// rand.New(NewRandSource(f)).Perm(n).
+//
+// Since Source.Uint64 can't return an error, it panics if f fails, which only
+// happens after f is closed.
+func NewRandSource(f Fortuna) rand.Source {
+	return randSource{f}
+}
+
+func (r randSource) Uint64() uint64 {
+	v, err := readUint64(r.f)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ShuffleSeeded pseudo-randomizes the order of n elements with a Fisher-Yates
+// shuffle, calling swap to swap the elements with indexes i and j. It panics
+// if n < 0.
+//
+// The permutation is fully determined by seed so it is reproducible across
+// runs and machines, e.g. to randomize the order of tests. It must not be used
+// when the order must be unpredictable.
+func ShuffleSeeded(seed []byte, n int, swap func(i, j int)) {
+	if n < 0 {
+		panic("invalid argument to ShuffleSeeded")
+	}
+	// Always call Write() so an empty seed still initializes the generator.
+	g := newGenerator(nil, nil)
+	_, _ = g.Write(seed)
+	for i := n - 1; i > 0; i-- {
+		j, err := uint64n(g, uint64(i+1))
+		if err != nil {
+			panic(err) // A seeded generator never fails.
+		}
+		swap(i, int(j))
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestUint64nZero(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	if _, err := prng.Uint64n(0); err == nil {
+		t.Fatal("No error set")
+	}
+}
+
+func TestUint64nRange(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	for _, n := range []uint64{1, 2, 3, 1 << 32, 1<<63 + 1, ^uint64(0)} {
+		for i := 0; i < 100; i++ {
+			v, err := prng.Uint64n(n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v >= n {
+				t.Fatalf("Uint64n(%d) returned %d", n, v)
+			}
+		}
+	}
+}
+
+func TestUint64nDistribution(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	const n = 10
+	const draws = 100000
+	var buckets [n]int
+	for i := 0; i < draws; i++ {
+		v, err := prng.Uint64n(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buckets[v]++
+	}
+	// Each bucket is expected to get 10000 hits with a standard deviation of
+	// ~95, so a 5% tolerance is more than 5 sigmas.
+	for i, c := range buckets {
+		if c < draws/n*95/100 || c > draws/n*105/100 {
+			t.Fatalf("Bucket %d has %d hits: %v", i, c, buckets)
+		}
+	}
+}
+
+func TestInt(t *testing.T) {
+	t.Parallel()
+	if bits.UintSize != 64 {
+		t.Skip("Requires a 64 bits platform")
+	}
+	prng := newFortuna(t)
+	// With 64 draws, the probability of never having the top bit set is 2^-64.
+	high := false
+	for i := 0; i < 64; i++ {
+		v, err := prng.Int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v < 0 {
+			t.Fatalf("Int() returned %d", v)
+		}
+		if v >= 1<<62 {
+			high = true
+		}
+	}
+	if !high {
+		t.Fatal("Int() doesn't span the positive range")
+	}
+}
+
+func TestIntnPanics(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	for _, n := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Intn(%d) didn't panic", n)
+				}
+			}()
+			_, _ = prng.Intn(n)
+		}()
+	}
+}
+
+func TestIntnDistribution(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	const n = 4
+	const draws = 40000
+	var buckets [n]int
+	for i := 0; i < draws; i++ {
+		v, err := prng.Intn(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buckets[v]++
+	}
+	for i, c := range buckets {
+		if c < draws/n*95/100 || c > draws/n*105/100 {
+			t.Fatalf("Bucket %d has %d hits: %v", i, c, buckets)
+		}
+	}
+}
+
+func TestRandSource(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	r := rand.New(NewRandSource(prng))
+	seen := map[uint64]bool{}
+	for i := 0; i < 1000; i++ {
+		v := r.Uint64()
+		if seen[v] {
+			t.Fatalf("%d returned twice", v)
+		}
+		seen[v] = true
+	}
+	if p := r.Perm(10); len(p) != 10 {
+		t.Fatalf("Got %v", p)
+	}
+	_ = prng.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Uint64 didn't panic")
+		}
+	}()
+	r.Uint64()
+}
+
+func shuffleSwaps(seed []byte, n int) [][2]int {
+	var swaps [][2]int
+	ShuffleSeeded(seed, n, func(i, j int) {
+		swaps = append(swaps, [2]int{i, j})
+	})
+	return swaps
+}
+
+func TestShuffleSeeded(t *testing.T) {
+	t.Parallel()
+	s1 := shuffleSwaps([]byte("seed"), 100)
+	if len(s1) != 99 {
+		t.Fatalf("Got %d swaps", len(s1))
+	}
+	for _, s := range s1 {
+		if s[1] < 0 || s[1] > s[0] {
+			t.Fatalf("Invalid swap %v", s)
+		}
+	}
+	if s2 := shuffleSwaps([]byte("seed"), 100); !reflect.DeepEqual(s1, s2) {
+		t.Fatalf("%v != %v", s1, s2)
+	}
+	if s2 := shuffleSwaps([]byte("other"), 100); reflect.DeepEqual(s1, s2) {
+		t.Fatal("Different seeds produced the same permutation")
+	}
+	if s2 := shuffleSwaps(nil, 100); len(s2) != 99 {
+		t.Fatalf("Got %d swaps", len(s2))
+	}
+	if s := shuffleSwaps([]byte("seed"), 1); len(s) != 0 {
+		t.Fatalf("Got %v", s)
+	}
+}
+
+func TestInt63(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	high := false
+	seen := map[int64]bool{}
+	for i := 0; i < 2*int63CacheSize+1; i++ {
+		v, err := prng.Int63()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v < 0 {
+			t.Fatalf("Int63() returned %d", v)
+		}
+		if v >= 1<<62 {
+			high = true
+		}
+		if seen[v] {
+			t.Fatalf("%d returned twice", v)
+		}
+		seen[v] = true
+	}
+	if !high {
+		t.Fatal("Int63() doesn't span the positive range")
+	}
+	_ = prng.Close()
+	if prng.int63.buf != [len(prng.int63.buf)]byte{} {
+		t.Fatal("Cache not wiped")
+	}
+	if _, err := prng.Int63(); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestInt63Reseed(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	for i, f := range []func() error{
+		func() error { prng.ForceReseed(); return nil },
+		func() error { prng.ReseedDrainAll(); return nil },
+		prng.ReseedFromSystem,
+		func() error {
+			_, err := prng.Generator().Write([]byte("seed"))
+			return err
+		},
+		func() error { return prng.SetGenerator(NewGenerator(nil, []byte("seed"))) },
+	} {
+		if _, err := prng.Int63(); err != nil {
+			t.Fatal(err)
+		}
+		if prng.int63.left == 0 {
+			t.Fatalf("%d: Cache not filled", i)
+		}
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+		if prng.int63.left != 0 || prng.int63.buf != [len(prng.int63.buf)]byte{} {
+			t.Fatalf("%d: Cache not wiped", i)
+		}
+	}
+}
+
+func TestInt63Cache(t *testing.T) {
+	t.Parallel()
+	// The cache must return the same values as reading 8 bytes per call, only
+	// the grouping of the generator requests differs.
+	g := newGenerator(nil, []byte("seed"))
+	expected := make([]byte, int63CacheSize*8)
+	if _, err := cloneGenerator(g).Read(expected); err != nil {
+		t.Fatal(err)
+	}
+	c := int63Cache{}
+	for i := 0; i < int63CacheSize; i++ {
+		v, err := c.next(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e := int64(binary.LittleEndian.Uint64(expected[8*i:]) >> 1); v != e {
+			t.Fatalf("%d: %d != %d", i, v, e)
+		}
+	}
+	if c.left != 0 {
+		t.Fatalf("Got %d", c.left)
+	}
+}
+
+func BenchmarkInt63(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := f.Int63(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Reads 8 bytes per call, to compare with BenchmarkInt63.
+func BenchmarkInt63Uncached(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v, err := readUint64(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = int64(v >> 1)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// RDRANDSource is an EntropySource that emits the output of the RDRAND
+// instruction of Intel and AMD CPUs.
+//
+// Like any other source, its output is only mixed in the pools and is never
+// trusted alone; Fortuna stays secure even if RDRAND is backdoored or broken.
+// It silently does nothing on CPUs and architectures without RDRAND.
+type RDRANDSource struct {
+	// Interval between two events. Defaults to 100ms, the reseed interval.
+	Interval time.Duration
+}
+
+// Collect emits 32 bytes of RDRAND output every Interval.
+func (r *RDRANDSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	if !hasRDRAND() {
+		return
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = reseedInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var b [32]byte
+		if rdrandRead(b[:]) {
+			add(b[:])
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// rdrandRead fills b, which length must be a multiple of 8, with RDRAND
+// output. It returns false if RDRAND is not available or keeps failing.
+func rdrandRead(b []byte) bool {
+	for i := 0; i < len(b); i += 8 {
+		// Intel recommends retrying 10 times on underflow.
+		ok := false
+		for j := 0; j < 10 && !ok; j++ {
+			var v uint64
+			if v, ok = rdrand64(); ok {
+				binary.LittleEndian.PutUint64(b[i:], v)
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+// hasRDRAND returns true if CPUID reports support for RDRAND.
+func hasRDRAND() bool
+
+// rdrand64 executes RDRAND once. It returns false if no random value was
+// available.
+func rdrand64() (uint64, bool)
@@ -0,0 +1,24 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func hasRDRAND() bool
+TEXT ·hasRDRAND(SB), NOSPLIT, $0-1
+	MOVL $1, AX
+	XORL CX, CX
+	CPUID
+	// CPUID.01H:ECX.RDRAND[bit 30]
+	SHRL $30, CX
+	ANDL $1, CX
+	MOVB CX, ret+0(FP)
+	RET
+
+// func rdrand64() (uint64, bool)
+TEXT ·rdrand64(SB), NOSPLIT, $0-9
+	RDRANDQ AX
+	// CF is set when a value is available.
+	SETCS ret1+8(FP)
+	MOVQ AX, ret+0(FP)
+	RET
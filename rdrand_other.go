@@ -0,0 +1,15 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build !amd64
+
+package fortuna
+
+func hasRDRAND() bool {
+	return false
+}
+
+func rdrand64() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,45 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRDRANDSource(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	var samples [][]byte
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s := &RDRANDSource{Interval: time.Millisecond}
+		s.Collect(ctx, func(data ...[]byte) {
+			samples = append(samples, append([]byte{}, data[0]...))
+			if len(samples) == 3 {
+				cancel()
+			}
+		})
+	}()
+	if !hasRDRAND() {
+		// It must return right away without emitting anything.
+		<-done
+		cancel()
+		if len(samples) != 0 {
+			t.Fatalf("Got %d samples", len(samples))
+		}
+		t.Skip("RDRAND is not supported")
+	}
+	<-done
+	if len(samples) != 3 {
+		t.Fatalf("Got %d samples", len(samples))
+	}
+	if bytes.Equal(samples[0], samples[1]) || bytes.Equal(samples[1], samples[2]) {
+		t.Fatalf("Constant samples: %v", samples)
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type fallbackReader struct {
+	primary  io.Reader
+	fallback io.Reader
+}
+
+// WithFallback returns a reader that reads from primary and, on error or short
+// read, reads the remainder from fallback, e.g. crypto/rand.Reader.
+//
+// Each Read fills the buffer completely unless fallback fails too.
+func WithFallback(primary io.Reader, fallback io.Reader) io.Reader {
+	return &fallbackReader{primary, fallback}
+}
+
+func (f *fallbackReader) Read(p []byte) (int, error) {
+	n, err := f.primary.Read(p)
+	if err == nil && n == len(p) {
+		return n, nil
+	}
+	m, err := io.ReadFull(f.fallback, p[n:])
+	return n + m, err
+}
+
+type fullReader struct {
+	f Fortuna
+}
+
+// NewFullReader returns a reader that always fills the buffer completely,
+// calling f.Read as many times as needed, unless an error occurs.
+//
+// Fortuna.Read returns at most 1Mb per call; this is the safe choice for code
+// that assumes a single Read fills the buffer.
+func NewFullReader(f Fortuna) io.Reader {
+	return &fullReader{f}
+}
+
+func (r *fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(r.f, p)
+}
+
+// Limit returns a reader that returns exactly n bytes of random data from f,
+// then io.EOF. Reads larger than the 1Mb request cap are done with multiple
+// calls to f.Read.
+func Limit(f Fortuna, n int64) io.Reader {
+	return io.LimitReader(&fullReader{f}, n)
+}
+
+type combinedReader struct {
+	readers []io.Reader
+
+	lock sync.Mutex
+	temp []byte // Scratch space used to read the readers after the first one
+}
+
+// Combine returns a reader that XORs the output of readers, so its output is
+// unpredictable as long as one of the readers is, e.g. multiple Fortuna
+// instances seeded from different sources. It panics if readers is empty.
+//
+// Each Read reads as much as the first reader returns, then fills the same
+// amount from each of the other readers.
+func Combine(readers ...io.Reader) io.Reader {
+	if len(readers) == 0 {
+		panic("invalid argument to Combine")
+	}
+	return &combinedReader{readers: readers}
+}
+
+func (c *combinedReader) Read(p []byte) (int, error) {
+	n, err := c.readers[0].Read(p)
+	if n == 0 || len(c.readers) == 1 {
+		return n, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.temp) < n {
+		c.temp = make([]byte, n)
+	}
+	t := c.temp[:n]
+	defer Wipe(t)
+	for _, r := range c.readers[1:] {
+		if _, err := io.ReadFull(r, t); err != nil {
+			// Do not return partially combined data.
+			Wipe(p[:n])
+			return 0, err
+		}
+		for i := range t {
+			p[i] ^= t[i]
+		}
+	}
+	return n, err
+}
+
+type prefetchReader struct {
+	chunks chan []byte
+	quit   chan struct{}
+	done   chan struct{}
+	closed <-chan struct{} // Closed when f is closed, if f is an accumulator
+
+	err    error       // Error returned by f; only read once failed is set or chunks is closed
+	failed atomic.Bool // Set by fill when f fails
+
+	lock    sync.Mutex
+	current []byte // Unread part of the current chunk
+	stopped bool
+}
+
+// NewPrefetchReader returns a reader that serves data that was generated in
+// advance by a background goroutine, in chunks of bufSize bytes, so Read
+// rarely waits on AES and the rekeying.
+//
+// The returned stop function stops the goroutine and wipes the unread data.
+// Reads after stop return ErrClosed. Once f fails, e.g. after it is closed, the
+// unread data is wiped and reads return f's error. Since the prefetched data
+// stays in memory until it is read, it is exposed to a compromise of the
+// process for longer than with reads done directly on f.
+func NewPrefetchReader(f Fortuna, bufSize int) (io.Reader, func()) {
+	if bufSize <= 0 {
+		panic("invalid bufSize")
+	}
+	p := &prefetchReader{
+		chunks: make(chan []byte, 4),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if a, ok := f.(*accumulator); ok {
+		p.closed = a.ctx.Done()
+	}
+	go p.fill(f, bufSize)
+	var once sync.Once
+	return p, func() { once.Do(p.stop) }
+}
+
+func (p *prefetchReader) fill(f Fortuna, bufSize int) {
+	defer close(p.done)
+	defer close(p.chunks)
+	for {
+		chunk := make([]byte, bufSize)
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			Wipe(chunk)
+			p.fail(err)
+			return
+		}
+		select {
+		case p.chunks <- chunk:
+		case <-p.quit:
+			Wipe(chunk)
+			return
+		case <-p.closed:
+			Wipe(chunk)
+			p.fail(ErrClosed)
+			return
+		}
+	}
+}
+
+// fail records err and wipes the chunks not yet read.
+func (p *prefetchReader) fail(err error) {
+	p.err = err
+	p.failed.Store(true)
+	for {
+		select {
+		case chunk := <-p.chunks:
+			Wipe(chunk)
+		default:
+			return
+		}
+	}
+}
+
+func (p *prefetchReader) stop() {
+	close(p.quit)
+	<-p.done
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.stopped = true
+	Wipe(p.current)
+	p.current = nil
+	for chunk := range p.chunks {
+		Wipe(chunk)
+	}
+}
+
+func (p *prefetchReader) Read(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := 0
+	for n < len(b) {
+		if p.stopped {
+			return n, ErrClosed
+		}
+		select {
+		case <-p.closed:
+			// Don't return the data prefetched before f was closed.
+			Wipe(p.current)
+			p.current = nil
+			return n, ErrClosed
+		default:
+		}
+		if p.failed.Load() {
+			Wipe(p.current)
+			p.current = nil
+			return n, p.err
+		}
+		if len(p.current) == 0 {
+			chunk, ok := <-p.chunks
+			if !ok {
+				if p.err == nil {
+					// Stopped while waiting.
+					return n, ErrClosed
+				}
+				return n, p.err
+			}
+			if p.failed.Load() {
+				Wipe(chunk)
+				return n, p.err
+			}
+			p.current = chunk
+		}
+		c := copy(b[n:], p.current)
+		Wipe(p.current[:c])
+		p.current = p.current[c:]
+		n += c
+	}
+	return n, nil
+}
+
+type stripe struct {
+	lock sync.Mutex
+	g    *generator // Seeded from f on first use
+}
+
+type stripedReader struct {
+	f       Fortuna
+	next    atomic.Uint32
+	stripes []stripe
+}
+
+// NewStripedReader returns a reader that round-robins the reads among stripes
+// generators, each one with its own lock, so concurrent readers contend less
+// than on a single generator. It panics if stripes <= 0.
+//
+// Each generator is seeded with 32 bytes read from f on its first use; the
+// error is returned if this read fails. The generators are never reseeded
+// afterward so the output doesn't benefit from the entropy added to f later.
+func NewStripedReader(f Fortuna, stripes int) io.Reader {
+	if stripes <= 0 {
+		panic("invalid argument to NewStripedReader")
+	}
+	return &stripedReader{f: f, stripes: make([]stripe, stripes)}
+}
+
+func (r *stripedReader) Read(p []byte) (int, error) {
+	s := &r.stripes[(r.next.Add(1)-1)%uint32(len(r.stripes))]
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.g == nil {
+		var seed [32]byte
+		if _, err := io.ReadFull(r.f, seed[:]); err != nil {
+			return 0, err
+		}
+		s.g = newGenerator(nil, seed[:])
+		Wipe(seed[:])
+	}
+	return s.g.Read(p)
+}
+
+type rateLimitedReader struct {
+	r    io.Reader
+	rate int
+
+	lock   sync.Mutex
+	tokens float64   // Bytes that can be read right away, up to rate
+	last   time.Time // Last time tokens was refilled
+}
+
+// NewRateLimitedReader returns a reader that reads from r at most
+// bytesPerSec bytes per second on average, with bursts of up to bytesPerSec
+// bytes. It panics if bytesPerSec <= 0.
+//
+// Concurrent reads share the same budget. The reader also has the method
+// ReadContext(ctx context.Context, p []byte) (int, error) to stop waiting
+// when ctx is done; it calls r's ReadContext, e.g. Fortuna.ReadContext, when
+// available.
+func NewRateLimitedReader(r io.Reader, bytesPerSec int) io.Reader {
+	if bytesPerSec <= 0 {
+		panic("invalid argument to NewRateLimitedReader")
+	}
+	return &rateLimitedReader{r: r, rate: bytesPerSec, tokens: float64(bytesPerSec), last: time.Now()}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	return r.ReadContext(context.Background(), p)
+}
+
+// ReadContext waits until enough budget is available to read min(len(p),
+// bytesPerSec) bytes, then reads up to this size.
+func (r *rateLimitedReader) ReadContext(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > r.rate {
+		p = p[:r.rate]
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for {
+		now := time.Now()
+		r.tokens += now.Sub(r.last).Seconds() * float64(r.rate)
+		if r.tokens > float64(r.rate) {
+			r.tokens = float64(r.rate)
+		}
+		r.last = now
+		missing := float64(len(p)) - r.tokens
+		if missing <= 0 {
+			break
+		}
+		// Holding the lock while waiting makes the concurrent readers wait in
+		// turn.
+		t := time.NewTimer(time.Duration(missing / float64(r.rate) * float64(time.Second)))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return 0, ctx.Err()
+		}
+	}
+	var n int
+	var err error
+	if c, ok := r.r.(interface {
+		ReadContext(context.Context, []byte) (int, error)
+	}); ok {
+		n, err = c.ReadContext(ctx, p)
+	} else {
+		n, err = r.r.Read(p)
+	}
+	r.tokens -= float64(n)
+	return n, err
+}
+
+// NewPipeReader returns the read half of a pipe that a background goroutine
+// continuously fills with random data from f, e.g. to compose with code
+// consuming an io.Reader.
+//
+// The goroutine exits once the reader is closed. If f fails, e.g. after it is
+// closed, the reader returns f's error.
+func NewPipeReader(f Fortuna) *io.PipeReader {
+	r, _ := newPipeReader(f)
+	return r
+}
+
+// newPipeReader returns the pipe reader and a channel closed when the
+// goroutine exits.
+func newPipeReader(f Fortuna) (*io.PipeReader, <-chan struct{}) {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var buf [4096]byte
+		defer Wipe(buf[:])
+		for {
+			n, err := f.Read(buf[:])
+			if err != nil {
+				_ = w.CloseWithError(err)
+				return
+			}
+			// Write blocks until the data is read and fails once the reader is
+			// closed.
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+	return r, done
+}
+
+// ErrDuplicateOutput is returned by the reader returned by NewComparingReader
+// when the primary and the reference return the same data.
+var ErrDuplicateOutput = errors.New("primary and reference returned the same data")
+
+// compareInterval is the number of reads between two comparisons done by
+// NewComparingReader.
+const compareInterval = 16
+
+// minCompareSize is the minimum read size compared by NewComparingReader, so
+// a match by chance is practically impossible.
+const minCompareSize = 16
+
+type comparingReader struct {
+	primary   io.Reader
+	reference io.Reader
+
+	lock sync.Mutex
+	left int    // Number of reads large enough to skip before the next comparison
+	buf  []byte // Scratch space for the reference data
+}
+
+// NewComparingReader returns a reader that reads from primary and, for each
+// read, reads the same amount from reference so both stay at the same position
+// in their streams. The data is compared for the first read of at least 16
+// bytes then every 16 such reads, and ErrDuplicateOutput is returned if both
+// are equal.
+//
+// reference must be an independently seeded instance. Equal output denotes a
+// catastrophic shared state, e.g. a process forked after the construction.
+// The data is wiped before ErrDuplicateOutput is returned.
+func NewComparingReader(primary, reference io.Reader) io.Reader {
+	return &comparingReader{primary: primary, reference: reference}
+}
+
+func (c *comparingReader) Read(p []byte) (int, error) {
+	// Both reads are done under the lock so concurrent reads stay in step.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n, err := c.primary.Read(p)
+	if cap(c.buf) < n {
+		c.buf = make([]byte, n)
+	}
+	b := c.buf[:n]
+	defer Wipe(b)
+	if _, err2 := io.ReadFull(c.reference, b); err2 != nil {
+		return n, err2
+	}
+	if n < minCompareSize {
+		return n, err
+	}
+	if c.left != 0 {
+		c.left--
+		return n, err
+	}
+	c.left = compareInterval - 1
+	if bytes.Equal(p[:n], b) {
+		Wipe(p[:n])
+		return 0, ErrDuplicateOutput
+	}
+	return n, err
+}
@@ -0,0 +1,476 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingReader returns up to n bytes of 0xFF then fails.
+type failingReader struct {
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("failing")
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	for i := range p {
+		p[i] = 0xFF
+	}
+	f.n -= len(p)
+	return len(p), nil
+}
+
+func TestWithFallback(t *testing.T) {
+	t.Parallel()
+	r := WithFallback(&failingReader{10}, bytes.NewReader(make([]byte, 1000)))
+	data := make([]byte, 16)
+	read(t, r, data, 16)
+	expected := append(bytes.Repeat([]byte{0xFF}, 10), make([]byte, 6)...)
+	if !bytes.Equal(expected, data) {
+		t.Fatalf("%v != %v", data, expected)
+	}
+	// The primary is now completely failing.
+	read(t, r, data, 16)
+	if !isZero(data) {
+		t.Fatalf("%v", data)
+	}
+}
+
+func TestWithFallbackFails(t *testing.T) {
+	t.Parallel()
+	r := WithFallback(&failingReader{10}, &failingReader{2})
+	n, err := r.Read(make([]byte, 16))
+	if n != 12 || err == nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+}
+
+func TestFullReader(t *testing.T) {
+	t.Parallel()
+	r := NewFullReader(newFortuna(t))
+	data := make([]byte, 2<<20)
+	read(t, r, data, len(data))
+	if isZero(data[len(data)-32:]) {
+		t.Fatal("Not filled")
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	t.Parallel()
+	const n = 1<<20 + 10
+	r := Limit(newFortuna(t), n)
+	data := make([]byte, n+1)
+	if l, err := io.ReadFull(r, data); l != n || err != io.ErrUnexpectedEOF {
+		t.Fatalf("Got %d, %v", l, err)
+	}
+	if l, err := r.Read(data); l != 0 || err != io.EOF {
+		t.Fatalf("Got %d, %v", l, err)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	t.Parallel()
+	a := newFortuna(t)
+	b := newGenerator(nil, []byte{0})
+	ga := cloneGenerator(&a.generator)
+	gb := cloneGenerator(b)
+	actual := make([]byte, 100)
+	read(t, Combine(a, b), actual, len(actual))
+	outA := make([]byte, 100)
+	read(t, ga, outA, len(outA))
+	outB := make([]byte, 100)
+	read(t, gb, outB, len(outB))
+	if bytes.Equal(actual, outA) || bytes.Equal(actual, outB) {
+		t.Fatal("The output is not combined")
+	}
+	for i := range outA {
+		outA[i] ^= outB[i]
+	}
+	if !bytes.Equal(outA, actual) {
+		t.Fatalf("%v != %v", actual, outA)
+	}
+
+	// Combining with zeros is a no-op.
+	gb = cloneGenerator(b)
+	read(t, Combine(bytes.NewReader(make([]byte, 100)), b), actual, len(actual))
+	read(t, gb, outB, len(outB))
+	if !bytes.Equal(outB, actual) {
+		t.Fatalf("%v != %v", actual, outB)
+	}
+}
+
+func TestCombineCap(t *testing.T) {
+	t.Parallel()
+	// The first reader caps each Read at 1Mb, the second fills the same amount.
+	r := Combine(newFortuna(t), NewFullReader(newFortuna(t)))
+	data := make([]byte, 2<<20)
+	if n, err := r.Read(data); n != 1<<20 || err != nil {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if _, err := Combine(bytes.NewReader(make([]byte, 10)), bytes.NewReader(nil)).Read(data); err == nil {
+		t.Fatal("No error set")
+	}
+}
+
+func TestPrefetchReader(t *testing.T) {
+	t.Parallel()
+	r, stop := NewPrefetchReader(newFortuna(t), 100)
+	p := r.(*prefetchReader)
+	// Reads spanning multiple chunks.
+	data := make([]byte, 250)
+	read(t, r, data, len(data))
+	if isZero(data[200:]) {
+		t.Fatal("Not filled")
+	}
+	stop()
+	select {
+	case <-p.done:
+	default:
+		t.Fatal("The goroutine is still running")
+	}
+	if len(p.current) != 0 {
+		t.Fatal("Unread data wasn't released")
+	}
+	if n, err := r.Read(data); n != 0 || err != ErrClosed {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	// Calling stop twice is fine.
+	stop()
+}
+
+func TestPrefetchReaderError(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	r, stop := NewPrefetchReader(prng, 16)
+	defer stop()
+	p := r.(*prefetchReader)
+	data := make([]byte, 8)
+	read(t, r, data, len(data))
+	_ = prng.Close()
+	// Neither the rest of the current chunk nor the queued chunks are returned.
+	if n, err := r.Read(data); n != 0 || err != ErrClosed {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if p.current != nil {
+		t.Fatal("Unread data wasn't released")
+	}
+	<-p.done
+	if !p.failed.Load() || len(p.chunks) != 0 {
+		t.Fatal("Queued chunks not wiped")
+	}
+}
+
+// latencies returns the sorted durations of n reads of size bytes from r.
+func latencies(t *testing.T, r io.Reader, n, size int) []time.Duration {
+	data := make([]byte, size)
+	out := make([]time.Duration, n)
+	for i := range out {
+		start := time.Now()
+		read(t, r, data, size)
+		out[i] = time.Since(start)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func TestPrefetchReaderLatency(t *testing.T) {
+	t.Parallel()
+	f := newFortuna(t)
+	r, stop := NewPrefetchReader(f, 4096)
+	defer stop()
+	// Warm up so the chunks are prefetched.
+	read(t, r, make([]byte, 16), 16)
+	const n = 2000
+	direct := latencies(t, f, n, 16)
+	prefetched := latencies(t, r, n, 16)
+	// Most prefetched reads are a copy while each direct read does a rekey, so
+	// the median is lower. The tail isn't compared since it depends on the
+	// scheduling of the prefetching goroutine.
+	if p, d := prefetched[n/2], direct[n/2]; p > d {
+		t.Fatalf("Median prefetched %s > direct %s", p, d)
+	}
+}
+
+// Reads 16 bytes at a time to bench overhead. Calculates the cost per byte.
+// Compare with BenchmarkFortuna16Bytes.
+func BenchmarkPrefetchReader16Bytes(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r, stop := NewPrefetchReader(f, 4096)
+	defer stop()
+	data := make([]byte, 16)
+	count := 0
+	b.ResetTimer()
+
+	for count != b.N {
+		chunk := 16
+		if b.N-count < 16 {
+			chunk = b.N - count
+		}
+		n, err := r.Read(data[:chunk])
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != chunk {
+			b.Fatalf("Failed to read")
+		}
+		count += chunk
+	}
+}
+
+func TestStripedReader(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	r := NewStripedReader(prng, 4)
+	if err := CollisionTest(r, 1000, 16); err != nil {
+		t.Fatal(err)
+	}
+	// Each stripe was seeded once.
+	for i := range r.(*stripedReader).stripes {
+		if r.(*stripedReader).stripes[i].g == nil {
+			t.Fatalf("Stripe %d not seeded", i)
+		}
+	}
+	// The stripes not seeded yet fail once f is closed.
+	r = NewStripedReader(prng, 2)
+	read(t, r, make([]byte, 16), 16)
+	_ = prng.Close()
+	if _, err := r.Read(make([]byte, 16)); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	read(t, r, make([]byte, 16), 16)
+}
+
+func TestStripedReaderPanics(t *testing.T) {
+	t.Parallel()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewStripedReader didn't panic")
+		}
+	}()
+	NewStripedReader(newFortuna(t), 0)
+}
+
+// Reads 16 bytes at a time from concurrent goroutines, to compare with
+// BenchmarkGeneratorParallel16Bytes.
+func BenchmarkStripedReaderParallel16Bytes(b *testing.B) {
+	f, err := NewFortuna(make([]byte, 128))
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := NewStripedReader(f, 8)
+	b.SetBytes(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		data := make([]byte, 16)
+		for pb.Next() {
+			if _, err := r.Read(data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// Reads 16 bytes at a time from concurrent goroutines on a single generator.
+func BenchmarkGeneratorParallel16Bytes(b *testing.B) {
+	g := NewGenerator(nil, []byte{0})
+	b.SetBytes(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		data := make([]byte, 16)
+		for pb.Next() {
+			if _, err := g.Read(data); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	t.Parallel()
+	const rate = 10000
+	r := NewRateLimitedReader(newFortuna(t), rate)
+	start := time.Now()
+	// The first second worth of data is a burst, the rest must take ~0.5s.
+	if _, err := io.ReadFull(r, make([]byte, rate+rate/2)); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 450*time.Millisecond || d > 5*time.Second {
+		t.Fatalf("Took %s", d)
+	}
+
+	// The budget is exhausted so the read waits until ctx is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c := r.(interface {
+		ReadContext(context.Context, []byte) (int, error)
+	})
+	if n, err := c.ReadContext(ctx, make([]byte, rate)); n != 0 || err != context.DeadlineExceeded {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+}
+
+func TestRateLimitedReaderPanics(t *testing.T) {
+	t.Parallel()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewRateLimitedReader didn't panic")
+		}
+	}()
+	NewRateLimitedReader(newFortuna(t), 0)
+}
+
+func TestPipeReader(t *testing.T) {
+	t.Parallel()
+	r, done := newPipeReader(newFortuna(t))
+	data := make([]byte, 10000)
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatal(err)
+	}
+	if isZero(data[9000:]) {
+		t.Fatal("Not filled")
+	}
+	_ = r.Close()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("The goroutine is still running")
+	}
+}
+
+func TestPipeReaderError(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	r := NewPipeReader(prng)
+	defer r.Close()
+	_ = prng.Close()
+	if _, err := io.Copy(io.Discard, r); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+}
+
+func TestComparingReader(t *testing.T) {
+	t.Parallel()
+	// Independent seeds.
+	r := NewComparingReader(NewGenerator(nil, []byte("1")), NewGenerator(nil, []byte("2")))
+	for i := 0; i < 2*compareInterval; i++ {
+		read(t, r, make([]byte, 32), 32)
+	}
+
+	// Identical seeds; the reads too small to be compared pass.
+	r = NewComparingReader(NewGenerator(nil, []byte("1")), NewGenerator(nil, []byte("1")))
+	read(t, r, make([]byte, 1), 1)
+	r = NewComparingReader(NewGenerator(nil, []byte("1")), NewGenerator(nil, []byte("1")))
+	data := make([]byte, 32)
+	if n, err := r.Read(data); n != 0 || err != ErrDuplicateOutput {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+	if !isZero(data) {
+		t.Fatal("Not wiped")
+	}
+}
+
+// swapReader reads from r, which can be replaced between reads.
+type swapReader struct {
+	r io.Reader
+}
+
+func (s *swapReader) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func TestComparingReaderPeriodic(t *testing.T) {
+	t.Parallel()
+	g := newGenerator(nil, []byte("1"))
+	ref := &swapReader{newGenerator(nil, []byte("2"))}
+	r := NewComparingReader(g, ref)
+	read(t, r, make([]byte, 32), 32)
+	// The reference now shares the state of the primary, like after a fork.
+	ref.r = cloneGenerator(g)
+	for i := 1; i < compareInterval; i++ {
+		read(t, r, make([]byte, 32), 32)
+	}
+	if n, err := r.Read(make([]byte, 32)); n != 0 || err != ErrDuplicateOutput {
+		t.Fatalf("Got %d, %v", n, err)
+	}
+}
+
+// yieldingReader yields after each read of r, to interleave concurrent
+// readers.
+type yieldingReader struct {
+	lock sync.Mutex
+	r    io.Reader
+}
+
+func (y *yieldingReader) Read(p []byte) (int, error) {
+	y.lock.Lock()
+	n, err := y.r.Read(p)
+	y.lock.Unlock()
+	runtime.Gosched()
+	return n, err
+}
+
+func TestComparingReaderConcurrent(t *testing.T) {
+	t.Parallel()
+	// With identical streams, every compared read must match.
+	r := NewComparingReader(&yieldingReader{r: NewGenerator(nil, []byte("1"))}, NewGenerator(nil, []byte("1")))
+	var wg sync.WaitGroup
+	var dup atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := r.Read(make([]byte, 32)); err == ErrDuplicateOutput {
+					dup.Add(1)
+				} else if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 400 reads, compared once every compareInterval reads.
+	if d := dup.Load(); d != 400/compareInterval {
+		t.Fatalf("Got %d", d)
+	}
+}
+
+func TestComparingReaderSampled(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	ref := bytes.NewReader(make([]byte, 32*(compareInterval+1)))
+	c := NewComparingReader(prng, ref).(*comparingReader)
+	// The reference is read on every read but only compared once per
+	// compareInterval reads.
+	for i := 0; i < compareInterval+1; i++ {
+		read(t, c, make([]byte, 32), 32)
+	}
+	if c.left != compareInterval-1 || ref.Len() != 0 {
+		t.Fatalf("Got %d, %d", c.left, ref.Len())
+	}
+	// The reference is exhausted.
+	if _, err := c.Read(make([]byte, 32)); err != io.EOF {
+		t.Fatalf("Got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteSeedFile writes MinSeedSize bytes of random data from f to the file at
+// path, to be passed to NewFortuna on the next start.
+//
+// The file is replaced atomically and is only readable by the owner since its
+// content determines the output of the next instance.
+func WriteSeedFile(f Fortuna, path string) error {
+	seed := make([]byte, MinSeedSize)
+	defer Wipe(seed)
+	if _, err := io.ReadFull(f, seed); err != nil {
+		return err
+	}
+	t, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = t.Write(seed)
+	if err == nil {
+		// Otherwise a crash after the rename could leave an empty file.
+		err = t.Sync()
+	}
+	if err2 := t.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(t.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(t.Name())
+	}
+	return err
+}
+
+// UpdateSeedFileLoop calls WriteSeedFile each time tick fires and a last time
+// when ctx is done, then returns. tick is usually the channel of a ticker,
+// e.g. time.NewTicker(10*time.Minute).C.
+//
+// The file is not written until f.SeededFromEvents() is true, so a seed file
+// is never derived only from the previous one. It returns the first error from
+// WriteSeedFile.
+func UpdateSeedFileLoop(ctx context.Context, f Fortuna, path string, tick <-chan time.Time) error {
+	for {
+		select {
+		case <-tick:
+		case <-ctx.Done():
+			if !f.SeededFromEvents() {
+				return nil
+			}
+			return WriteSeedFile(f, path)
+		}
+		if f.SeededFromEvents() {
+			if err := WriteSeedFile(f, path); err != nil {
+				return err
+			}
+		}
+	}
+}
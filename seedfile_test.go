@@ -0,0 +1,98 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSeedFile(t *testing.T) {
+	t.Parallel()
+	p := filepath.Join(t.TempDir(), "seed")
+	if err := WriteSeedFile(newFortuna(t), p); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFortuna(seed); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(p); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("Got %v, %v", fi, err)
+	}
+	prng := newFortuna(t)
+	_ = prng.Close()
+	if err := WriteSeedFile(prng, p); err != ErrClosed {
+		t.Fatalf("Got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(p)); len(entries) != 1 {
+		t.Fatalf("Got %v", entries)
+	}
+}
+
+func TestUpdateSeedFileLoop(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	p := filepath.Join(t.TempDir(), "seed")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tick := make(chan time.Time)
+	done := make(chan error)
+	go func() {
+		done <- UpdateSeedFileLoop(ctx, prng, p, tick)
+	}()
+	// tick is unbuffered so once the second tick is received, the first one
+	// was processed.
+	tick <- time.Time{}
+	tick <- time.Time{}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("Written before being seeded: %v", err)
+	}
+
+	// Pool 1 still contains part of the initial seed; see
+	// TestSeededFromEvents.
+	prng.lock.Lock()
+	prng.nextPool = 1
+	prng.lock.Unlock()
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	tick <- time.Time{}
+	tick <- time.Time{}
+	if _, err := os.Stat(p); !prng.IsSeeded() || !os.IsNotExist(err) {
+		t.Fatalf("Written before being seeded from events: %v", err)
+	}
+
+	prng.lock.Lock()
+	prng.nextPool = 0
+	prng.lock.Unlock()
+	prng.AddRandomEventSync(1, make([]byte, 32))
+	prng.ForceReseed()
+	tick <- time.Time{}
+	tick <- time.Time{}
+	seed1, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Written on stop.
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	seed2, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seed2) != MinSeedSize || bytes.Equal(seed1, seed2) {
+		t.Fatal("The seed file was not updated on stop")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ReseedOnSignal calls f.ReseedFromSystem() each time one of the signals is
+// received, e.g. syscall.SIGHUP after rotating upstream secrets. The returned
+// function stops the handling.
+//
+// It uses signal.Notify so other channels registered by the caller for the
+// same signals still receive them.
+func ReseedOnSignal(f Fortuna, sig ...os.Signal) (stop func()) {
+	c := make(chan os.Signal, 1)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	signal.Notify(c, sig...)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-c:
+				_ = f.ReseedFromSystem()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(quit)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build !windows && !plan9
+
+package fortuna
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReseedOnSignal(t *testing.T) {
+	// Not parallel since it sends a signal to the whole process.
+	prng := newFortuna(t)
+	// The caller's own handler must still be notified.
+	own := make(chan os.Signal, 1)
+	signal.Notify(own, syscall.SIGHUP)
+	defer signal.Stop(own)
+	stop := ReseedOnSignal(prng, syscall.SIGHUP)
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-own:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The signal was swallowed")
+	}
+	for start := time.Now(); ; {
+		prng.lock.Lock()
+		n := prng.numReseed
+		prng.lock.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("No reseed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EntropySource is a background source of entropy, registered with
+// Fortuna.AddSource.
+type EntropySource interface {
+	// Collect gathers entropy and passes it to add, which has the same
+	// semantics as Fortuna.AddRandomEvent, until ctx is canceled.
+	Collect(ctx context.Context, add func(data ...[]byte))
+}
+
+func (a *accumulator) AddSource(source byte, s EntropySource) {
+	go s.Collect(a.ctx, func(data ...[]byte) {
+		a.AddRandomEvent(source, data...)
+	})
+}
+
+// OSRandomSource is an EntropySource that reads from crypto/rand.Reader, which
+// uses getrandom(2) or /dev/urandom on Linux, so an otherwise idle
+// accumulator keeps being reseeded.
+type OSRandomSource struct {
+	// Interval between two events. Defaults to 100ms, the reseed interval.
+	Interval time.Duration
+	// Warn, if set, is called with an error wrapping ErrWeakSeed each time
+	// crypto/rand fails and weak inputs are emitted instead.
+	Warn func(err error)
+}
+
+// Collect emits 32 bytes read from crypto/rand.Reader every Interval. If
+// crypto/rand fails, it emits weak inputs instead, like NewFortunaAutoSeed,
+// and calls Warn.
+func (o *OSRandomSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	interval := o.Interval
+	if interval <= 0 {
+		interval = reseedInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		var b [32]byte
+		if err := readSystemRand(b[:]); err != nil && o.Warn != nil {
+			o.Warn(err)
+		}
+		// Events are distributed over the pools in turn.
+		add(b[:])
+		Wipe(b[:])
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// GCTraceSource is an EntropySource that emits the duration of the garbage
+// collection pauses, which vary with the process load.
+//
+// It is a weak source, only meant to supplement others. It never triggers a
+// garbage collection itself but runtime.ReadMemStats briefly stops the world
+// on each sample.
+type GCTraceSource struct {
+	// Interval between two samples. Defaults to 1s.
+	Interval time.Duration
+}
+
+// Collect emits the pauses of the garbage collections that occurred since the
+// previous sample, if any, every Interval.
+func (g *GCTraceSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	interval := g.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	last := m.NumGC
+	var buf [len(m.PauseNs) * 8]byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		runtime.ReadMemStats(&m)
+		n := m.NumGC - last
+		if n == 0 {
+			continue
+		}
+		if n > uint32(len(m.PauseNs)) {
+			n = uint32(len(m.PauseNs))
+		}
+		// PauseNs is a circular buffer; the most recent pause is at
+		// PauseNs[(NumGC+255)%256].
+		for i := uint32(0); i < n; i++ {
+			binary.LittleEndian.PutUint64(buf[i*8:], m.PauseNs[(m.NumGC-i+255)%256])
+		}
+		add(buf[:n*8])
+		last = m.NumGC
+	}
+}
+
+type timeoutSource struct {
+	s       EntropySource
+	timeout time.Duration
+	retry   bool
+}
+
+// NewTimeoutSource returns an EntropySource that calls s.Collect with a
+// context that expires after timeout, e.g. for a source doing I/O that could
+// hang.
+//
+// If s.Collect doesn't return by the deadline, it is abandoned: its goroutine
+// keeps running but the events it emits afterward are dropped. When retry is
+// true, s.Collect is called again each time the deadline is reached, until the
+// parent context is canceled. If s.Collect returns before the deadline, it is
+// not called again.
+func NewTimeoutSource(s EntropySource, timeout time.Duration, retry bool) EntropySource {
+	return &timeoutSource{s, timeout, retry}
+}
+
+func (t *timeoutSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	for {
+		c, cancel := context.WithTimeout(ctx, t.timeout)
+		var abandoned atomic.Bool
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			t.s.Collect(c, func(data ...[]byte) {
+				if !abandoned.Load() {
+					add(data...)
+				}
+			})
+		}()
+		select {
+		case <-done:
+		case <-c.Done():
+			abandoned.Store(true)
+		}
+		expired := c.Err() != nil
+		cancel()
+		if !expired || !t.retry || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+type entropyHash struct {
+	hash.Hash
+	f Fortuna
+}
+
+// NewEntropyHash returns a hash that delegates to inner and also adds all the
+// data written to it to f as events from source 0, e.g. to hash request IDs.
+//
+// Only data that is hard to predict for an attacker is useful entropy, but
+// Fortuna is designed to be safe with attacker-controlled events.
+func NewEntropyHash(f Fortuna, inner hash.Hash) hash.Hash {
+	return &entropyHash{inner, f}
+}
+
+func (e *entropyHash) Write(p []byte) (int, error) {
+	e.f.AddRandomEvent(0, p)
+	return e.Hash.Write(p)
+}
+
+var (
+	sourcesLock sync.Mutex
+	// The sources used by this package are reserved.
+	sources = []string{
+		"fortuna",
+		"fortuna.Middleware timing",
+		"fortuna.Middleware remote address",
+		"fortuna.Middleware headers",
+	}
+)
+
+// RegisterSource allocates a unique source byte for AddRandomEvent, so that
+// independent subsystems don't use the same one. It returns an error if name
+// is already registered or if all the source bytes are allocated.
+//
+// The registry is process wide and purely advisory: AddRandomEvent accepts any
+// byte.
+func RegisterSource(name string) (byte, error) {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	for _, n := range sources {
+		if n == name {
+			return 0, fmt.Errorf("source %q is already registered", name)
+		}
+	}
+	if len(sources) == 256 {
+		return 0, errors.New("all the sources are registered")
+	}
+	sources = append(sources, name)
+	return byte(len(sources) - 1), nil
+}
+
+// SourceName returns the name of a source registered with RegisterSource, or
+// an empty string.
+func SourceName(b byte) string {
+	sourcesLock.Lock()
+	defer sourcesLock.Unlock()
+	if int(b) < len(sources) {
+		return sources[b]
+	}
+	return ""
+}
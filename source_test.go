@@ -0,0 +1,252 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// constantSource emits one event then waits for cancellation.
+type constantSource struct {
+	done chan struct{}
+}
+
+func (c *constantSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	defer close(c.done)
+	add([]byte{1, 2, 3})
+	<-ctx.Done()
+}
+
+func TestAddSource(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	s := &constantSource{make(chan struct{})}
+	prng.AddSource(7, s)
+	_ = prng.Close()
+	<-s.done
+}
+
+// stuckSource ignores its context and blocks until unblock is closed.
+type stuckSource struct {
+	calls   atomic.Int32
+	unblock chan struct{}
+}
+
+func (s *stuckSource) Collect(ctx context.Context, add func(data ...[]byte)) {
+	s.calls.Add(1)
+	add([]byte{1})
+	<-s.unblock
+	// Dropped, since it is emitted after the deadline.
+	add([]byte{2})
+}
+
+func TestAddSourceStuck(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	s := &stuckSource{unblock: make(chan struct{})}
+	defer close(s.unblock)
+	prng.AddSource(7, s)
+	start := time.Now()
+	_ = prng.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Close took %s", d)
+	}
+}
+
+func TestTimeoutSource(t *testing.T) {
+	t.Parallel()
+	s := &stuckSource{unblock: make(chan struct{})}
+	defer close(s.unblock)
+	var lock sync.Mutex
+	var events [][]byte
+	add := func(data ...[]byte) {
+		lock.Lock()
+		events = append(events, data...)
+		lock.Unlock()
+	}
+	NewTimeoutSource(s, time.Millisecond, false).Collect(context.Background(), add)
+	if n := s.calls.Load(); n != 1 {
+		t.Fatalf("Got %d", n)
+	}
+
+	// With retry, Collect is called until the parent context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewTimeoutSource(s, time.Millisecond, true).Collect(ctx, add)
+	}()
+	for s.calls.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	lock.Lock()
+	defer lock.Unlock()
+	for _, e := range events {
+		if !bytes.Equal(e, []byte{1}) {
+			t.Fatalf("Got %v", e)
+		}
+	}
+}
+
+func TestTimeoutSourceReturns(t *testing.T) {
+	t.Parallel()
+	// Even with retry, Collect returns once the parent context is canceled.
+	s := &constantSource{make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	NewTimeoutSource(s, time.Hour, true).Collect(ctx, func(data ...[]byte) {})
+	<-s.done
+}
+
+func TestOSRandomSource(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	defer prng.Close()
+	prng.AddSource(1, &OSRandomSource{Interval: time.Millisecond})
+	buffer := make([]byte, 1)
+	for start := time.Now(); ; {
+		read(t, prng, buffer, 1)
+		prng.lock.Lock()
+		n := prng.numReseed
+		prng.lock.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("No reseed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOSRandomSourceWeak(t *testing.T) {
+	// Not parallel since it replaces the process wide systemRand.
+	systemRand = iotest.ErrReader(errors.New("no entropy"))
+	defer func() {
+		systemRand = rand.Reader
+	}()
+	var warnings []error
+	o := &OSRandomSource{Warn: func(err error) { warnings = append(warnings, err) }}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// An event is emitted before the context is checked.
+	var events [][]byte
+	o.Collect(ctx, func(data ...[]byte) {
+		events = append(events, append([]byte{}, data[0]...))
+	})
+	if len(events) != 1 || isZero(events[0]) {
+		t.Fatalf("Got %v", events)
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrWeakSeed) {
+		t.Fatalf("Got %v", warnings)
+	}
+}
+
+func TestGCTraceSource(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	samples := make(chan []byte)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s := &GCTraceSource{Interval: time.Millisecond}
+		s.Collect(ctx, func(data ...[]byte) {
+			samples <- append([]byte{}, data[0]...)
+		})
+	}()
+	var got [][]byte
+	for len(got) < 2 {
+		// Generate garbage collections.
+		runtime.GC()
+		select {
+		case s := <-samples:
+			got = append(got, s)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	// Unblock a pending add.
+	select {
+	case <-samples:
+	case <-done:
+	}
+	<-done
+	if bytes.Equal(got[0], got[1]) {
+		t.Fatalf("Constant samples: %v", got)
+	}
+}
+
+func TestEntropyHash(t *testing.T) {
+	t.Parallel()
+	a := newAccumulator(Options{})
+	h := NewEntropyHash(a, sha256.New())
+	_, _ = h.Write([]byte("hello"))
+	_, _ = h.Write([]byte("world"))
+	expected := sha256.Sum256([]byte("helloworld"))
+	if actual := h.Sum(nil); !bytes.Equal(expected[:], actual) {
+		t.Fatalf("%x != %x", actual, expected)
+	}
+	a.Flush()
+	if a.pools[0].length != 7 || a.pools[1].length != 7 {
+		t.Fatalf("Got %d, %d", a.pools[0].length, a.pools[1].length)
+	}
+}
+
+func TestRegisterSource(t *testing.T) {
+	// Not parallel since it exhausts the process wide registry, which is
+	// restored afterward.
+	sourcesLock.Lock()
+	saved := append([]string{}, sources...)
+	sourcesLock.Unlock()
+	defer func() {
+		sourcesLock.Lock()
+		sources = saved
+		sourcesLock.Unlock()
+	}()
+	a, err := RegisterSource("test a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := RegisterSource("test b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b || a < 4 {
+		t.Fatalf("Got %d, %d", a, b)
+	}
+	if n := SourceName(a); n != "test a" {
+		t.Fatalf("Got %q", n)
+	}
+	if n := SourceName(0); n != "fortuna" {
+		t.Fatalf("Got %q", n)
+	}
+	if _, err := RegisterSource("test a"); err == nil {
+		t.Fatal("No error set")
+	}
+	for i := int(b) + 1; i < 256; i++ {
+		if _, err := RegisterSource(fmt.Sprintf("test %d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := RegisterSource("test full"); err == nil {
+		t.Fatal("No error set")
+	}
+	if n := SourceName(255); n != "test 255" {
+		t.Fatalf("Got %q", n)
+	}
+}
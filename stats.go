@@ -0,0 +1,44 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import "time"
+
+// Stats is a snapshot of the non-secret state of a Fortuna instance, returned
+// by Stats(). The JSON field names are stable.
+type Stats struct {
+	// NumReseed is the number of reseeds done since the construction.
+	NumReseed int `json:"num_reseed"`
+	// LastReseed is the time of the last reseed.
+	LastReseed time.Time `json:"last_reseed"`
+	// NextPool is the pool that receives the next event.
+	NextPool int `json:"next_pool"`
+	// PoolLengths is the amount of data in bytes accumulated in each pool
+	// since it was last used.
+	PoolLengths [numPools]int `json:"pool_lengths"`
+	// BytesGenerated is the number of bytes returned by the generator.
+	BytesGenerated uint64 `json:"bytes_generated"`
+	// DroppedEvents is the number of events dropped by Middleware because the
+	// accumulator was saturated.
+	DroppedEvents uint64 `json:"dropped_events"`
+}
+
+func (a *accumulator) Stats() Stats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	s := Stats{
+		NumReseed:     a.numReseed,
+		LastReseed:    a.lastReseed,
+		NextPool:      a.nextPool,
+		DroppedEvents: a.dropped.Load(),
+	}
+	for i := range a.pools {
+		s.PoolLengths[i] = a.pools[i].length
+	}
+	a.generator.lock.Lock()
+	s.BytesGenerated = a.generator.generated
+	a.generator.lock.Unlock()
+	return s
+}
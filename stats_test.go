@@ -0,0 +1,63 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	prng := newFortuna(t)
+	read(t, prng, make([]byte, 100), 100)
+	prng.AddRandomEventSync(1, make([]byte, 10))
+	s := prng.Stats()
+	if s.NumReseed != 1 || s.NextPool != 1 || s.BytesGenerated != 100 || !s.LastReseed.Equal(prng.LastReseed()) {
+		t.Fatalf("Got %+v", s)
+	}
+	if s.PoolLengths[0] != prng.pools[0].length {
+		t.Fatalf("Got %+v", s)
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for k, v := range fields {
+		types[k] = reflect.TypeOf(v).String()
+	}
+	expected := map[string]string{
+		"num_reseed":      "float64",
+		"last_reseed":     "string",
+		"next_pool":       "float64",
+		"pool_lengths":    "[]interface {}",
+		"bytes_generated": "float64",
+		"dropped_events":  "float64",
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("%v != %v", types, expected)
+	}
+	if l := len(fields["pool_lengths"].([]interface{})); l != numPools {
+		t.Fatalf("Got %d", l)
+	}
+	var actual Stats
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !actual.LastReseed.Equal(s.LastReseed) {
+		t.Fatalf("%v != %v", actual.LastReseed, s.LastReseed)
+	}
+	actual.LastReseed = s.LastReseed
+	if !reflect.DeepEqual(actual, s) {
+		t.Fatalf("%+v != %+v", actual, s)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Tune measures the generator throughput for a range of request sizes and
+// returns the fastest one, to be used as Options.MaxBytesPerRequest.
+//
+// It generates about 44Mb of pseudorandom data, roughly similar to
+// BenchmarkGeneratorLarge, which takes a few tens of milliseconds on a CPU
+// with AES instructions and much longer without. It is meant to be called
+// once at startup.
+func Tune() int {
+	const total = 4 << 20
+	const maxSize = 1 << 20
+	buf := make([]byte, maxSize)
+	best := maxSize
+	var bestDuration time.Duration
+	for size := 1 << 10; size <= maxSize; size <<= 1 {
+		g := newGenerator(nil, []byte{0})
+		g.maxBytesPerRequest = size
+		start := time.Now()
+		for count := 0; count < total; {
+			// Each Read is capped to size.
+			n, _ := g.Read(buf)
+			count += n
+		}
+		if d := time.Since(start); bestDuration == 0 || d < bestDuration {
+			best, bestDuration = size, d
+		}
+	}
+	return best
+}
+
+// MeasureThroughput reads from r as fast as possible for d and returns the
+// throughput in bytes per second, e.g. to size how many requests a node can
+// serve.
+//
+// It reads 4Kb at a time so concurrent readers of r only see the normal lock
+// contention. The data read is discarded.
+func MeasureThroughput(r io.Reader, d time.Duration) (float64, error) {
+	if d <= 0 {
+		return 0, errors.New("invalid argument to MeasureThroughput")
+	}
+	var buf [4096]byte
+	defer Wipe(buf[:])
+	total := 0
+	start := time.Now()
+	for {
+		n, err := r.Read(buf[:])
+		total += n
+		if err != nil {
+			return 0, err
+		}
+		if e := time.Since(start); e >= d {
+			return float64(total) / e.Seconds(), nil
+		}
+	}
+}
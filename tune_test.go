@@ -0,0 +1,69 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+	"time"
+)
+
+func TestTune(t *testing.T) {
+	t.Parallel()
+	m := Tune()
+	if m <= 0 || m > 1<<20 || m%aes.BlockSize != 0 {
+		t.Fatalf("Got %d", m)
+	}
+	f, err := NewFortunaWithOptions(make([]byte, 128), Options{MaxBytesPerRequest: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(t, f, make([]byte, m+1), m)
+}
+
+func TestMaxBytesPerRequestInvalid(t *testing.T) {
+	t.Parallel()
+	for _, m := range []int{-16, 15, 17, 1<<20 + 16} {
+		if _, err := NewFortunaWithOptions(make([]byte, 128), Options{MaxBytesPerRequest: m}); err == nil {
+			t.Fatalf("%d: No error set", m)
+		}
+	}
+}
+
+func TestMeasureThroughput(t *testing.T) {
+	t.Parallel()
+	f := newFortuna(t)
+	// Concurrent reads must keep working.
+	done := make(chan error)
+	go func() {
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			_, err = f.Read(make([]byte, 32))
+		}
+		done <- err
+	}()
+	r, err := MeasureThroughput(f, 20*time.Millisecond)
+	if err2 := <-done; err2 != nil {
+		t.Fatal(err2)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Even without AES instructions, more than 1Mb/s is expected.
+	if r < 1<<20 {
+		t.Fatalf("Got %f", r)
+	}
+}
+
+func TestMeasureThroughputFail(t *testing.T) {
+	t.Parallel()
+	if _, err := MeasureThroughput(newFortuna(t), 0); err == nil {
+		t.Fatal("No error set")
+	}
+	if _, err := MeasureThroughput(bytes.NewReader(make([]byte, 16)), time.Second); err == nil {
+		t.Fatal("No error set")
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"runtime"
+)
+
+// Wipe overwrites b with zeros, e.g. to clear key material once it's not
+// needed anymore.
+//
+// runtime.KeepAlive ensures the stores are not elided as dead. This is a best
+// effort: Go gives no guarantee that no other copy of the data exists, for
+// example on a goroutine stack that was moved when it grew, in a buffer that
+// was reallocated by append, or in memory that was swapped to disk.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	runtime.KeepAlive(b)
+}
@@ -0,0 +1,35 @@
+// Copyright 2013 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package fortuna
+
+import (
+	"testing"
+)
+
+func TestWipe(t *testing.T) {
+	t.Parallel()
+	b := []byte{1, 2, 3, 4, 5}
+	Wipe(b)
+	if !isZero(b) {
+		t.Fatalf("Got %v", b)
+	}
+	Wipe(nil)
+}
+
+// Benches wiping a 1kb buffer. A throughput way above the memory bandwidth
+// would mean the stores were optimized out.
+func BenchmarkWipe(b *testing.B) {
+	data := make([]byte, 1024)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		data[0] = 1
+		Wipe(data)
+	}
+	if data[0] != 0 {
+		b.Fatal("Not wiped")
+	}
+}